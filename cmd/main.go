@@ -8,6 +8,9 @@ import (
 	"rest-api/config"
 	"rest-api/internal/db"
 	"rest-api/internal/handlers"
+	"rest-api/internal/notify"
+	"rest-api/internal/storage"
+	"rest-api/utils"
 
 	"github.com/go-chi/chi/v5"
 )
@@ -16,6 +19,27 @@ func main() {
 	cfg := config.Load()
 	ctx := context.Background()
 
+	err := cfg.Validate()
+	if err != nil {
+		log.Fatalf("config : %v\n", err)
+	}
+
+	err = utils.InitKeyring(cfg.SecretKeyID, cfg.SecretKey, cfg.SecretKeysPrevious)
+	if err != nil {
+		log.Fatalf("keyring : %v\n", err)
+	}
+
+	err = utils.SetPasswordHasher(utils.PasswordHasher{
+		Algorithm:     cfg.PasswordAlgorithm,
+		BcryptCost:    cfg.BcryptCost,
+		Argon2Time:    uint32(cfg.Argon2Time),
+		Argon2Memory:  uint32(cfg.Argon2MemoryKiB),
+		Argon2Threads: uint8(cfg.Argon2Threads),
+	})
+	if err != nil {
+		log.Fatalf("password hasher : %v\n", err)
+	}
+
 	router := chi.NewRouter()
 
 	pool, err := db.InitDatabase(ctx, cfg)
@@ -24,7 +48,34 @@ func main() {
 	}
 	defer pool.Close()
 
-	api := handlers.NewAPI(pool)
+	err = db.ResealSecrets(ctx, pool)
+	if err != nil {
+		log.Fatalf("secrets : %v\n", err)
+	}
+
+	err = db.ReportRetiredRecoveryCodes(ctx, pool)
+	if err != nil {
+		log.Fatalf("recovery codes : %v\n", err)
+	}
+
+	err = db.CheckDefaultRole(ctx, pool, cfg.DefaultRole)
+	if err != nil {
+		log.Fatalf("roles : %v\n", err)
+	}
+
+	db.StartTokenCleanup(ctx, pool, cfg.TokenCleanupInterval)
+
+	notifier, err := notify.New(cfg)
+	if err != nil {
+		log.Fatalf("notifier : %v\n", err)
+	}
+
+	blobs, err := storage.New(cfg)
+	if err != nil {
+		log.Fatalf("storage : %v\n", err)
+	}
+
+	api := handlers.NewAPI(pool, cfg, notifier, blobs)
 
 	api.RegisterAll(router)
 
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"rest-api/internal/oidc"
+	"testing"
+)
+
+// startMockIDP serves the mock provider on a local port and returns an API
+// side provider configured against it.
+func startMockIDP(t *testing.T) *oidc.Provider {
+	t.Helper()
+	srv := httptest.NewUnstartedServer(nil)
+	issuer := "http://" + srv.Listener.Addr().String()
+	p, err := newProvider(issuer, "rest-api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Config.Handler = p.routes()
+	srv.Start()
+	t.Cleanup(srv.Close)
+
+	return oidc.NewProvider(oidc.Config{
+		Issuer:      issuer,
+		ClientID:    "rest-api",
+		RedirectURL: "http://localhost:8080/auth/oidc/callback",
+	})
+}
+
+// authorize follows the authorization URL and returns the code from the
+// redirect back to the API.
+func authorize(t *testing.T, authURL string) string {
+	t.Helper()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(authURL + "&login_hint=alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize: got status %d", resp.StatusCode)
+	}
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return location.Query().Get("code")
+}
+
+func TestMockIDPFlow(t *testing.T) {
+	ctx := context.Background()
+	provider := startMockIDP(t)
+
+	verifier, _ := oidc.GenerateCodeVerifier()
+	authURL, err := provider.AuthCodeURL(ctx, "state", "nonce-1", oidc.CodeChallengeS256(verifier))
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := provider.Exchange(ctx, authorize(t, authURL), verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := provider.VerifyIDToken(ctx, token.IDToken, "nonce-1")
+	if err != nil {
+		t.Fatalf("valid token rejected: %v", err)
+	}
+	if claims.Subject != "alice" {
+		t.Errorf("subject = %q, want alice", claims.Subject)
+	}
+
+	_, err = provider.VerifyIDToken(ctx, token.IDToken, "other-nonce")
+	if !errors.Is(err, oidc.ErrInvalidIDToken) {
+		t.Errorf("nonce mismatch: got %v, want ErrInvalidIDToken", err)
+	}
+}
+
+func TestMockIDPRejectsWrongVerifier(t *testing.T) {
+	ctx := context.Background()
+	provider := startMockIDP(t)
+
+	verifier, _ := oidc.GenerateCodeVerifier()
+	authURL, err := provider.AuthCodeURL(ctx, "state", "nonce", oidc.CodeChallengeS256(verifier))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _ := oidc.GenerateCodeVerifier()
+	_, err = provider.Exchange(ctx, authorize(t, authURL), other)
+	if err == nil {
+		t.Fatal("exchange with a wrong PKCE verifier succeeded")
+	}
+}
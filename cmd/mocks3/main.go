@@ -0,0 +1,252 @@
+// Command mocks3 is a tiny S3-compatible object store for local development.
+// It serves a single bucket from a directory, checks Signature Version 4 on
+// every request and answers Range requests, which is enough to exercise the
+// s3 storage backend of the API end to end:
+//
+//	go run ./cmd/mocks3
+//	STORAGE_BACKEND=s3 S3_ENDPOINT=http://localhost:9100 S3_BUCKET=attachments \
+//		S3_ACCESS_KEY_ID=mock S3_SECRET_ACCESS_KEY=mock-secret go run ./cmd
+//
+// Objects are addressed path-style: /<bucket>/<key>.
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type store struct {
+	dir             string
+	bucket          string
+	region          string
+	accessKeyID     string
+	secretAccessKey string
+
+	mu           sync.Mutex
+	contentTypes map[string]string
+}
+
+func getEnv(key, def string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return def
+}
+
+func main() {
+	addr := getEnv("MOCK_S3_ADDR", ":9100")
+
+	s := &store{
+		dir:             getEnv("MOCK_S3_DIR", filepath.Join(os.TempDir(), "mocks3")),
+		bucket:          getEnv("MOCK_S3_BUCKET", "attachments"),
+		region:          getEnv("MOCK_S3_REGION", "us-east-1"),
+		accessKeyID:     getEnv("MOCK_S3_ACCESS_KEY_ID", "mock"),
+		secretAccessKey: getEnv("MOCK_S3_SECRET_ACCESS_KEY", "mock-secret"),
+		contentTypes:    map[string]string{},
+	}
+	err := os.MkdirAll(s.dir, 0700)
+	if err != nil {
+		log.Fatalf("data dir : %v\n", err)
+	}
+
+	fmt.Println("Mock S3 bucket", s.bucket, "in", s.dir, "listening on", addr)
+	log.Fatal(http.ListenAndServe(addr, s))
+}
+
+func s3Error(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<Error><Code>%s</Code><Message>%s</Message></Error>", code, message)
+}
+
+func (s *store) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := s.verify(r); err != nil {
+		s3Error(w, http.StatusForbidden, "SignatureDoesNotMatch", err.Error())
+		return
+	}
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != s.bucket {
+		s3Error(w, http.StatusNotFound, "NoSuchBucket", "the specified bucket does not exist")
+		return
+	}
+	path := filepath.Join(s.dir, filepath.FromSlash(filepath.Clean("/"+key)))
+	if key == "" || path == s.dir {
+		s3Error(w, http.StatusBadRequest, "InvalidRequest", "an object key is required")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		s.put(w, r, key, path)
+	case http.MethodGet, http.MethodHead:
+		s.get(w, r, key, path)
+	case http.MethodDelete:
+		os.Remove(path)
+		s.mu.Lock()
+		delete(s.contentTypes, key)
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		s3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "unsupported method")
+	}
+}
+
+func (s *store) put(w http.ResponseWriter, r *http.Request, key, path string) {
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		s3Error(w, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		s3Error(w, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	defer f.Close()
+
+	hasher := sha256.New()
+	_, err = io.Copy(io.MultiWriter(f, hasher), r.Body)
+	if err != nil {
+		s3Error(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+	sum := hex.EncodeToString(hasher.Sum(nil))
+	if declared := r.Header.Get("X-Amz-Content-Sha256"); declared != "UNSIGNED-PAYLOAD" && declared != sum {
+		os.Remove(path)
+		s3Error(w, http.StatusBadRequest, "XAmzContentSHA256Mismatch", "payload hash does not match")
+		return
+	}
+
+	s.mu.Lock()
+	s.contentTypes[key] = r.Header.Get("Content-Type")
+	s.mu.Unlock()
+	w.Header().Set("ETag", `"`+sum[:32]+`"`)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *store) get(w http.ResponseWriter, r *http.Request, key, path string) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		s3Error(w, http.StatusNotFound, "NoSuchKey", "the specified key does not exist")
+		return
+	}
+	if err != nil {
+		s3Error(w, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		s3Error(w, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	contentType := s.contentTypes[key]
+	s.mu.Unlock()
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	http.ServeContent(w, r, "", info.ModTime(), f)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// verify recomputes the Signature Version 4 of a request signed in the
+// Authorization header. Query-string presigning is not supported.
+func (s *store) verify(r *http.Request) error {
+	auth, ok := strings.CutPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ")
+	if !ok {
+		return errors.New("missing AWS4-HMAC-SHA256 authorization")
+	}
+	fields := map[string]string{}
+	for _, field := range strings.Split(auth, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(field), "=")
+		fields[name] = value
+	}
+
+	scopeParts := strings.Split(fields["Credential"], "/")
+	if len(scopeParts) != 5 || scopeParts[0] != s.accessKeyID {
+		return errors.New("unknown access key")
+	}
+	date, region := scopeParts[1], scopeParts[2]
+	if region != s.region || scopeParts[3] != "s3" || scopeParts[4] != "aws4_request" {
+		return errors.New("invalid credential scope")
+	}
+	amzDate := r.Header.Get("X-Amz-Date")
+	signedAt, err := time.Parse("20060102T150405Z", amzDate)
+	if err != nil || !strings.HasPrefix(amzDate, date) {
+		return errors.New("invalid x-amz-date")
+	}
+	if d := time.Since(signedAt); d > 15*time.Minute || d < -15*time.Minute {
+		return errors.New("request time too skewed")
+	}
+
+	signedHeaders := strings.Split(fields["SignedHeaders"], ";")
+	var canonicalHeaders strings.Builder
+	for _, name := range signedHeaders {
+		value := r.Header.Get(name)
+		if name == "host" {
+			value = r.Host
+		}
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+
+	query := r.URL.Query()
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalQuery []string
+	for _, name := range names {
+		for _, value := range query[name] {
+			canonicalQuery = append(canonicalQuery, name+"="+value)
+		}
+	}
+
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		r.URL.EscapedPath(),
+		strings.Join(canonicalQuery, "&"),
+		canonicalHeaders.String(),
+		fields["SignedHeaders"],
+		r.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+	digest := sha256.Sum256([]byte(canonicalRequest))
+	scope := date + "/" + region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(digest[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.secretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	expected := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	if !hmac.Equal([]byte(expected), []byte(fields["Signature"])) {
+		return errors.New("signature does not match")
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package audit
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const (
+	ActionImpersonationStart   = "impersonation.start"
+	ActionImpersonationRequest = "impersonation.request"
+	ActionImpersonationEnd     = "impersonation.end"
+	ActionTwoFactorReset       = "two_factor.reset"
+)
+
+type Entry struct {
+	ActorID int64
+	UserID  int64
+	Action  string
+	Details map[string]any
+	IP      string
+}
+
+type execer interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
+
+// Record appends an entry to the audit log. Pass a transaction to make the
+// entry part of the change it describes.
+func Record(ctx context.Context, db execer, entry Entry) error {
+	details := entry.Details
+	if details == nil {
+		details = map[string]any{}
+	}
+	_, err := db.Exec(
+		ctx,
+		"insert into audit_log(actor_id, user_id, action, details, ip) values ($1, $2, $3, $4, $5)",
+		entry.ActorID, entry.UserID, entry.Action, details, entry.IP,
+	)
+	return err
+}
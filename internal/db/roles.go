@@ -0,0 +1,22 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// CheckDefaultRole makes sure the role given to new users exists, so sign-ups
+// do not end up without any role.
+func CheckDefaultRole(ctx context.Context, pool *pgxpool.Pool, name string) error {
+	var exists bool
+	err := pool.QueryRow(ctx, "select exists(select 1 from roles where name = $1)", name).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("default role %q does not exist", name)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"log"
+	"rest-api/utils"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ResealSecrets encrypts TOTP secrets that are still stored in plaintext or
+// under a previous key with the current key. It runs at startup, so a key can
+// be dropped from SECRET_KEYS_PREVIOUS once the service has been restarted
+// with its successor.
+func ResealSecrets(ctx context.Context, pool *pgxpool.Pool) error {
+	rows, err := pool.Query(ctx, "select id, totp_secret from users where totp_secret is not null")
+	if err != nil {
+		return err
+	}
+
+	stale := map[int64]string{}
+	for rows.Next() {
+		var (
+			id     int64
+			stored string
+		)
+		err := rows.Scan(&id, &stored)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		if utils.SecretNeedsReseal(stored) {
+			stale[id] = stored
+		}
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		return rows.Err()
+	}
+
+	for id, stored := range stale {
+		secret, err := utils.DecryptSecret(stored)
+		if errors.Is(err, utils.ErrSecretKeyUnknown) {
+			log.Printf("secrets : totp secret of user %d was sealed with a retired key, the user needs a recovery code or an admin 2FA reset (POST /users/%d/2fa/reset)\n", id, id)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		sealed, err := utils.EncryptSecret(secret)
+		if err != nil {
+			return err
+		}
+		_, err = pool.Exec(
+			ctx,
+			"update users set totp_secret = $1 where id = $2 and totp_secret = $3",
+			sealed, id, stored,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package db
+
+import (
+	"context"
+	"log"
+	"rest-api/utils"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// StartTokenCleanup periodically removes expired tokens and everything that
+// was hashed with a key no longer present in the keyring. Such tokens can
+// never match again, so keeping them only hides stale rows from admins.
+// Recovery codes are the exception, see ReportRetiredRecoveryCodes.
+func StartTokenCleanup(ctx context.Context, pool *pgxpool.Pool, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			err := CleanupTokens(ctx, pool)
+			if err != nil {
+				log.Printf("token cleanup : %v\n", err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func CleanupTokens(ctx context.Context, pool *pgxpool.Pool) error {
+	keyIDs := utils.KeyIDs()
+
+	statements := []string{
+		`delete from sessions
+		 where not (key_id = any($1))
+		    or (coalesce(refresh_expires_at, expires_at) <= now())`,
+		"update api_keys set revoked_at = now() where revoked_at is null and not (key_id = any($1))",
+		"delete from two_factor_challenges where not (key_id = any($1)) or expires_at <= now()",
+		"delete from password_resets where not (key_id = any($1)) or expires_at <= now() - interval '1 day'",
+		"delete from oidc_states where not (key_id = any($1)) or expires_at <= now()",
+		"delete from invitations where used_at is null and (not (key_id = any($1)) or expires_at <= now() - interval '1 day')",
+	}
+
+	for _, sql := range statements {
+		_, err := pool.Exec(ctx, sql, keyIDs)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReportRetiredRecoveryCodes logs the users whose unused recovery codes were
+// all hashed with keys that have left the keyring. Such codes can never match
+// again; the rows are kept so these users can still be found here until they
+// regenerate their codes, or an admin resets their 2FA if they cannot sign
+// in. The keyring only changes on restart, so this runs once at startup.
+func ReportRetiredRecoveryCodes(ctx context.Context, pool *pgxpool.Pool) error {
+	rows, err := pool.Query(
+		ctx,
+		`select u.id, u.login
+		 from users u
+		 where exists(select 1 from recovery_codes where user_id = u.id and used_at is null)
+		   and not exists(select 1 from recovery_codes where user_id = u.id and used_at is null and key_id = any($1))
+		 order by u.id`,
+		utils.KeyIDs(),
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			id    int64
+			login string
+		)
+		err := rows.Scan(&id, &login)
+		if err != nil {
+			return err
+		}
+		log.Printf("recovery codes of %s (id %d) were hashed with a retired key and no longer work, they must be regenerated\n", login, id)
+	}
+	return rows.Err()
+}
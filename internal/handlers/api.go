@@ -1,15 +1,38 @@
 package handlers
 
 import (
+	"rest-api/config"
+	"rest-api/internal/notify"
+	"rest-api/internal/oidc"
+	"rest-api/internal/storage"
+	"strings"
+
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type API struct {
-	Pool *pgxpool.Pool
+	Pool     *pgxpool.Pool
+	Config   *config.Config
+	Notifier notify.Notifier
+	OIDC     *oidc.Provider
+	Storage  storage.Storage
 }
 
-func NewAPI(pool *pgxpool.Pool) *API {
-	return &API{
-		Pool: pool,
+func NewAPI(pool *pgxpool.Pool, cfg *config.Config, notifier notify.Notifier, blobs storage.Storage) *API {
+	api := &API{
+		Pool:     pool,
+		Config:   cfg,
+		Notifier: notifier,
+		Storage:  blobs,
+	}
+	if cfg.OIDCIssuer != "" {
+		api.OIDC = oidc.NewProvider(oidc.Config{
+			Issuer:       cfg.OIDCIssuer,
+			ClientID:     cfg.OIDCClientID,
+			ClientSecret: cfg.OIDCClientSecret,
+			RedirectURL:  cfg.OIDCRedirectURL,
+			Scopes:       strings.Fields(cfg.OIDCScopes),
+		})
 	}
+	return api
 }
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"net/http"
+	"rest-api/internal/middlewares"
+	"rest-api/internal/models"
+	"rest-api/internal/rbac"
+	"rest-api/utils"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const apiKeyDisplayPrefixLen = 12
+
+func (api *API) RegisterAPIKeys(r chi.Router) {
+	r.Group(func(gr chi.Router) {
+		gr.Use(middlewares.AuthCheck(api.Pool, api.Config))
+		gr.Use(middlewares.RequireSession)
+		gr.Use(middlewares.NoImpersonation)
+		gr.Get("/auth/api-keys", api.getAPIKeys)
+		gr.Post("/auth/api-keys", api.createAPIKey)
+		gr.Delete("/auth/api-keys/{id}", api.revokeAPIKey)
+	})
+}
+
+func (api *API) getAPIKeys(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middlewares.UserIDKey).(int64)
+	if !ok || userID == 0 {
+		utils.WriteJSONError(w, http.StatusUnauthorized, "not_authorized", "you are not authorized")
+		return
+	}
+
+	rows, err := api.Pool.Query(
+		r.Context(),
+		`select id, name, prefix, scopes, expires_at, last_used_at, created_at
+		 from api_keys
+		 where user_id = $1 and revoked_at is null
+		 order by id`,
+		userID,
+	)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to fetch api keys")
+		return
+	}
+	defer rows.Close()
+
+	keys := []models.ApiKeyResponse{}
+	for rows.Next() {
+		var key models.ApiKeyResponse
+		err := rows.Scan(
+			&key.Id,
+			&key.Name,
+			&key.Prefix,
+			&key.Scopes,
+			&key.ExpiresAt,
+			&key.LastUsedAt,
+			&key.CreatedAt,
+		)
+		if err != nil {
+			utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to scan api key row")
+			return
+		}
+		keys = append(keys, key)
+	}
+
+	utils.WriteJSON(w, http.StatusOK, keys)
+}
+
+func (api *API) createAPIKey(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middlewares.UserIDKey).(int64)
+	if !ok || userID == 0 {
+		utils.WriteJSONError(w, http.StatusUnauthorized, "not_authorized", "you are not authorized")
+		return
+	}
+
+	var req models.ApiKeyRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		utils.WriteJSONValidationError(w, "name", "name is required")
+		return
+	}
+	if len(req.Scopes) == 0 {
+		utils.WriteJSONValidationError(w, "scopes", "at least one scope is required")
+		return
+	}
+	for _, scope := range req.Scopes {
+		if !rbac.IsKnown(scope) {
+			utils.WriteJSONValidationError(w, "scopes", "unknown scope "+scope)
+			return
+		}
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		utils.WriteJSONValidationError(w, "expires_at", "expires_at must be in the future")
+		return
+	}
+
+	key, err := utils.GenerateAPIKey()
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "token_generation_failed", "failed to create api key")
+		return
+	}
+
+	resp := models.ApiKeyCreatedResponse{
+		ApiKeyResponse: models.ApiKeyResponse{
+			Name:      req.Name,
+			Prefix:    key[:apiKeyDisplayPrefixLen],
+			Scopes:    req.Scopes,
+			ExpiresAt: req.ExpiresAt,
+		},
+		Key: key,
+	}
+	err = api.Pool.QueryRow(
+		r.Context(),
+		`insert into api_keys(user_id, name, prefix, key_hash, key_id, scopes, expires_at)
+		 values ($1, $2, $3, $4, $5, $6, $7)
+		 returning id, created_at`,
+		userID, resp.Name, resp.Prefix, utils.HashTokenHMAC(key), utils.CurrentKeyID(), resp.Scopes, resp.ExpiresAt,
+	).Scan(&resp.Id, &resp.CreatedAt)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to save api key")
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, resp)
+}
+
+func (api *API) revokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middlewares.UserIDKey).(int64)
+	if !ok || userID == 0 {
+		utils.WriteJSONError(w, http.StatusUnauthorized, "not_authorized", "you are not authorized")
+		return
+	}
+
+	keyID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || keyID <= 0 {
+		utils.WriteJSONError(w, http.StatusBadRequest, "invalid_api_key_id", "api key id must be a positive integer")
+		return
+	}
+
+	tag, err := api.Pool.Exec(
+		r.Context(),
+		"update api_keys set revoked_at = now() where id = $1 and user_id = $2 and revoked_at is null",
+		keyID, userID,
+	)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to revoke api key")
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		utils.WriteJSONError(w, http.StatusNotFound, "not_found", "api key with this id does not exist")
+		return
+	}
+	utils.WriteJSONSuccess(w, http.StatusOK)
+}
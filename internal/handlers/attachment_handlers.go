@@ -0,0 +1,362 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"rest-api/internal/middlewares"
+	"rest-api/internal/models"
+	"rest-api/internal/rbac"
+	"rest-api/internal/storage"
+	"rest-api/utils"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+)
+
+const maxAttachmentFilenameLength = 255
+
+// multipartOverhead is allowed on top of the attachment size for the
+// multipart boundaries and part headers.
+const multipartOverhead = 1 << 20
+
+func (api *API) RegisterAttachments(r chi.Router) {
+	r.Group(func(gr chi.Router) {
+		gr.Use(middlewares.AuthCheck(api.Pool, api.Config))
+		gr.Use(middlewares.AddUserStatus(api.Pool, api.Config))
+
+		gr.With(api.require(rbac.TasksRead)).Get("/tasks/{id}/attachments", api.getAttachments)
+		gr.With(api.require(rbac.TasksAttach)).Post("/tasks/{id}/attachments", api.uploadAttachment)
+		gr.With(api.require(rbac.TasksRead)).Get("/tasks/{id}/attachments/{attachmentId}", api.downloadAttachment)
+		gr.With(api.require(rbac.TasksAttach)).Delete("/tasks/{id}/attachments/{attachmentId}", api.deleteAttachment)
+	})
+}
+
+func attachmentIDParam(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	attachmentID, err := strconv.ParseInt(chi.URLParam(r, "attachmentId"), 10, 64)
+	if err != nil || attachmentID <= 0 {
+		utils.WriteJSONError(w, http.StatusBadRequest, "invalid_attachment_id", "attachment id must be a positive integer")
+		return 0, false
+	}
+	return attachmentID, true
+}
+
+func (api *API) attachmentTypeAllowed(contentType string) bool {
+	for _, allowed := range strings.Split(api.Config.AttachmentTypes, ",") {
+		if strings.EqualFold(strings.TrimSpace(allowed), contentType) {
+			return true
+		}
+	}
+	return false
+}
+
+func newAttachmentKey(taskID int64) (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("tasks/%d/%s", taskID, hex.EncodeToString(b)), nil
+}
+
+// cleanFilename keeps only the last path element of a client-supplied name.
+func cleanFilename(name string) string {
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, name)
+	return strings.TrimSpace(name)
+}
+
+// deleteBlobs removes stored attachment contents after their metadata is
+// gone. Failures only leave unreferenced blobs behind, so they are logged.
+// Callers pass a context that outlives the request, so a client going away
+// does not leave those blobs behind.
+func (api *API) deleteBlobs(ctx context.Context, keys []string) {
+	for _, key := range keys {
+		err := api.Storage.Delete(ctx, key)
+		if err != nil {
+			fmt.Println("storage : ", err)
+		}
+	}
+}
+
+func (api *API) getAttachments(w http.ResponseWriter, r *http.Request) {
+	taskID, ok := taskIDParam(w, r)
+	if !ok || !api.checkTaskAccess(w, r, taskID) {
+		return
+	}
+
+	rows, err := api.Pool.Query(
+		r.Context(),
+		`select id, task_id, uploader_id, filename, content_type, size, sha256, created_at
+		 from task_attachments where task_id = $1 order by id`,
+		taskID,
+	)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to fetch attachments")
+		return
+	}
+	defer rows.Close()
+
+	attachments := []models.AttachmentResponse{}
+	for rows.Next() {
+		var a models.AttachmentResponse
+		err := rows.Scan(&a.Id, &a.TaskId, &a.UploaderId, &a.Filename, &a.ContentType, &a.Size, &a.Sha256, &a.CreatedAt)
+		if err != nil {
+			utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to scan attachment row")
+			return
+		}
+		attachments = append(attachments, a)
+	}
+
+	utils.WriteJSON(w, http.StatusOK, attachments)
+}
+
+// uploadAttachment accepts a multipart form with a "file" part. The part is
+// spooled to a temporary file while its size is checked and its hash
+// computed, then handed to the storage backend.
+func (api *API) uploadAttachment(w http.ResponseWriter, r *http.Request) {
+	taskID, ok := taskIDParam(w, r)
+	if !ok || !api.checkTaskAccess(w, r, taskID) {
+		return
+	}
+	userID, _ := r.Context().Value(middlewares.UserIDKey).(int64)
+	maxSize := int64(api.Config.AttachmentMaxSize)
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxSize+multipartOverhead)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusBadRequest, "invalid_request", "request body must be multipart/form-data")
+		return
+	}
+
+	var (
+		filename     string
+		declaredType string
+		tmp          *os.File
+		size         int64
+		hasher       = sha256.New()
+	)
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			utils.WriteJSONError(w, http.StatusBadRequest, "invalid_request", "failed to read multipart body")
+			return
+		}
+		if part.FormName() != "file" || part.FileName() == "" {
+			part.Close()
+			continue
+		}
+
+		filename = cleanFilename(part.FileName())
+		declaredType = part.Header.Get("Content-Type")
+		tmp, err = os.CreateTemp("", "attachment-*")
+		if err != nil {
+			utils.WriteJSONError(w, http.StatusInternalServerError, "storage_error", "failed to store attachment")
+			return
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+
+		size, err = io.Copy(io.MultiWriter(tmp, hasher), io.LimitReader(part, maxSize+1))
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) || size > maxSize {
+			utils.WriteJSONError(w, http.StatusRequestEntityTooLarge, "file_too_large",
+				fmt.Sprintf("attachments must not exceed %d bytes", maxSize))
+			return
+		}
+		if err != nil {
+			utils.WriteJSONError(w, http.StatusBadRequest, "invalid_request", "failed to read uploaded file")
+			return
+		}
+		break
+	}
+
+	if tmp == nil {
+		utils.WriteJSONValidationError(w, "file", "file is required")
+		return
+	}
+	if filename == "" || utf8.RuneCountInString(filename) > maxAttachmentFilenameLength {
+		utils.WriteJSONValidationError(w, "file", fmt.Sprintf("file name must be 1 to %d characters", maxAttachmentFilenameLength))
+		return
+	}
+
+	// The declared type wins unless it is missing or generic; then the type
+	// is sniffed from the first bytes of the file.
+	contentType, _, _ := mime.ParseMediaType(declaredType)
+	if contentType == "" || contentType == "application/octet-stream" {
+		head := make([]byte, 512)
+		n, _ := tmp.ReadAt(head, 0)
+		contentType, _, _ = mime.ParseMediaType(http.DetectContentType(head[:n]))
+	}
+	contentType = strings.ToLower(contentType)
+	if !api.attachmentTypeAllowed(contentType) {
+		utils.WriteJSONError(w, http.StatusUnsupportedMediaType, "unsupported_media_type",
+			fmt.Sprintf("attachments of type %s are not allowed", contentType))
+		return
+	}
+
+	key, err := newAttachmentKey(taskID)
+	if err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	if err == nil {
+		err = api.Storage.Put(r.Context(), key, tmp, size, contentType)
+	}
+	if err != nil {
+		fmt.Println("storage : ", err)
+		utils.WriteJSONError(w, http.StatusInternalServerError, "storage_error", "failed to store attachment")
+		return
+	}
+
+	attachment := models.AttachmentResponse{
+		TaskId:      taskID,
+		UploaderId:  &userID,
+		Filename:    filename,
+		ContentType: contentType,
+		Size:        size,
+		Sha256:      hex.EncodeToString(hasher.Sum(nil)),
+	}
+	err = api.Pool.QueryRow(
+		r.Context(),
+		`insert into task_attachments(task_id, uploader_id, filename, content_type, size, sha256, storage_key)
+		 values ($1, $2, $3, $4, $5, $6, $7) returning id, created_at`,
+		taskID, userID, filename, contentType, size, attachment.Sha256, key,
+	).Scan(&attachment.Id, &attachment.CreatedAt)
+	if err != nil {
+		api.deleteBlobs(context.WithoutCancel(r.Context()), []string{key})
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to save attachment")
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, attachment)
+}
+
+// downloadAttachment streams the contents. http.ServeContent answers Range
+// and If-Range requests; the SHA-256 doubles as a strong ETag.
+func (api *API) downloadAttachment(w http.ResponseWriter, r *http.Request) {
+	taskID, ok := taskIDParam(w, r)
+	if !ok || !api.checkTaskAccess(w, r, taskID) {
+		return
+	}
+	attachmentID, ok := attachmentIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	var (
+		filename    string
+		contentType string
+		sha         string
+		key         string
+		createdAt   time.Time
+	)
+	err := api.Pool.QueryRow(
+		r.Context(),
+		"select filename, content_type, sha256, storage_key, created_at from task_attachments where id = $1 and task_id = $2",
+		attachmentID, taskID,
+	).Scan(&filename, &contentType, &sha, &key, &createdAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		utils.WriteJSONError(w, http.StatusNotFound, "not_found", "attachment with this id does not exist")
+		return
+	}
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to fetch attachment")
+		return
+	}
+
+	object, err := api.Storage.Open(r.Context(), key)
+	if errors.Is(err, storage.ErrNotFound) {
+		utils.WriteJSONError(w, http.StatusNotFound, "not_found", "attachment contents are missing")
+		return
+	}
+	if err != nil {
+		fmt.Println("storage : ", err)
+		utils.WriteJSONError(w, http.StatusInternalServerError, "storage_error", "failed to open attachment")
+		return
+	}
+	defer object.Close()
+
+	disposition := mime.FormatMediaType("attachment", map[string]string{"filename": filename})
+	if disposition == "" {
+		disposition = "attachment"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", disposition)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("ETag", `"`+sha+`"`)
+	http.ServeContent(w, r, "", createdAt, object)
+}
+
+// deleteAttachment is allowed to the uploader and to anyone who may delete
+// the task itself.
+func (api *API) deleteAttachment(w http.ResponseWriter, r *http.Request) {
+	taskID, ok := taskIDParam(w, r)
+	if !ok || !api.checkTaskAccess(w, r, taskID) {
+		return
+	}
+	attachmentID, ok := attachmentIDParam(w, r)
+	if !ok {
+		return
+	}
+	userID, _ := r.Context().Value(middlewares.UserIDKey).(int64)
+
+	var uploaderID *int64
+	err := api.Pool.QueryRow(
+		r.Context(),
+		"select uploader_id from task_attachments where id = $1 and task_id = $2",
+		attachmentID, taskID,
+	).Scan(&uploaderID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		utils.WriteJSONError(w, http.StatusNotFound, "not_found", "attachment with this id does not exist")
+		return
+	}
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to fetch attachment")
+		return
+	}
+
+	isUploader := uploaderID != nil && *uploaderID == userID
+	if !isUploader && !middlewares.HasPermission(r.Context(), rbac.TasksDelete) {
+		utils.WriteJSONError(w, http.StatusForbidden, "forbidden", "only the uploader can delete an attachment")
+		return
+	}
+
+	var key string
+	err = api.Pool.QueryRow(
+		r.Context(),
+		"delete from task_attachments where id = $1 returning storage_key",
+		attachmentID,
+	).Scan(&key)
+	if errors.Is(err, pgx.ErrNoRows) {
+		utils.WriteJSONError(w, http.StatusNotFound, "not_found", "attachment with this id does not exist")
+		return
+	}
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to delete attachment")
+		return
+	}
+	api.deleteBlobs(context.WithoutCancel(r.Context()), []string{key})
+
+	utils.WriteJSONSuccess(w, http.StatusOK)
+}
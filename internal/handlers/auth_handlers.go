@@ -1,7 +1,9 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -9,22 +11,26 @@ import (
 	"rest-api/internal/middlewares"
 	"rest-api/internal/models"
 	"rest-api/utils"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
 )
 
 type userData struct {
-	Login    string `json:"login"`
-	Password string `json:"password"`
+	Login             string `json:"login"`
+	Password          string `json:"password"`
+	IssueRefreshToken bool   `json:"issue_refresh_token"`
 }
 
 func (api *API) RegisterAuth(r chi.Router) {
 	r.Group(func(gr chi.Router) {
-		gr.Use(middlewares.AuthCheck(api.Pool))
+		gr.Use(middlewares.AuthCheck(api.Pool, api.Config))
 		gr.Get("/auth/me", api.aboutMe)
 	})
 	r.Post("/auth/login", api.loginHandler)
 	r.Post("/auth/logout", api.logoutHandler)
+	r.Post("/auth/refresh", api.refreshHandler)
 }
 
 func (api *API) loginHandler(w http.ResponseWriter, r *http.Request) {
@@ -52,76 +58,125 @@ func (api *API) loginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	loginKey := loginAttemptKey(user.Login)
+	ipKey := ipAttemptKey(utils.ClientIP(r))
+	lockedUntil, err := api.loginBlockedUntil(r.Context(), loginKey, ipKey)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to check login attempts")
+		return
+	}
+	if !lockedUntil.IsZero() {
+		utils.WriteJSONRetryAfter(w, time.Until(lockedUntil), "too_many_attempts", "too many failed login attempts, try again later")
+		return
+	}
+
 	var (
 		passwordHash string
 		id           int64
-		isAdmin      bool
-		family       string
-		name         string
-		surname      string
+		totpEnabled  bool
+		profile      models.UserProfileResponse
 	)
 	row := api.Pool.QueryRow(
 		r.Context(),
-		"select id, password_hash, is_admin, family, name, surname from users where login = $1",
+		"select id, password_hash, "+isAdminColumn+", family, name, surname, totp_enabled from users where login = $1",
 		user.Login,
 	)
 	err = row.Scan(
 		&id,
 		&passwordHash,
-		&isAdmin,
-		&family,
-		&name,
-		&surname,
+		&profile.IsAdmin,
+		&profile.Family,
+		&profile.Name,
+		&profile.Surname,
+		&totpEnabled,
 	)
 	if err != nil {
-		utils.WriteJSONError(w, http.StatusUnauthorized, "invalid_credentials", "user does not exist or password is incorrect")
+		api.rejectLogin(w, r, loginKey, ipKey)
 		return
 	}
 	err = utils.ComparePassword(user.Password, passwordHash)
 	if err != nil {
-		utils.WriteJSONError(w, http.StatusUnauthorized, "invalid_credentials", "user does not exist or password is incorrect")
+		api.rejectLogin(w, r, loginKey, ipKey)
 		return
 	}
+	profile.Id = int(id)
 
-	token, err := utils.GenerateSessionToken()
-	if err != nil {
-		utils.WriteJSONError(w, http.StatusInternalServerError, "token_generation_failed", "failed to create token")
+	if utils.PasswordNeedsRehash(passwordHash) {
+		api.upgradePasswordHash(r.Context(), id, user.Password, passwordHash)
+	}
+
+	// With 2FA the failures are cleared only once the second factor checks
+	// out, see loginTwoFactorHandler.
+	if totpEnabled {
+		api.startTwoFactorChallenge(w, r, id, user.IssueRefreshToken)
 		return
 	}
 
-	tokenHash := utils.HashTokenHMAC(token)
-	_, err = api.Pool.Exec(r.Context(), "insert into sessions(user_id, token_hash) values ($1, $2)", id, tokenHash)
+	err = api.clearLoginFailures(r.Context(), loginKey)
 	if err != nil {
-		utils.WriteJSONError(w, http.StatusInternalServerError, "session_save_failed", "failed to save session token")
-		return
+		fmt.Println("database : ", err)
 	}
 
-	cookie := &http.Cookie{
-		Name:     "session_token",
-		Value:    token,
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   true,
-		SameSite: http.SameSiteStrictMode,
+	api.issueLogin(w, r, profile, user.IssueRefreshToken)
+}
+
+func (api *API) issueLogin(w http.ResponseWriter, r *http.Request, profile models.UserProfileResponse, withRefresh bool) {
+	opts := newSessionOptions(r)
+	opts.withRefresh = withRefresh
+	session, err := api.createSession(r.Context(), api.Pool, int64(profile.Id), opts)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "session_save_failed", "failed to save session token")
+		return
 	}
 
-	http.SetCookie(w, cookie)
+	utils.SetSessionCookie(w, session.Token, api.Config.SessionCookieExpiry(session.ExpiresAt, session.LastUsedAt))
+	utils.SetCSRFCookie(w, session.CSRFToken, session.ExpiresAt, api.Config.CSRFCookieDomain)
 
 	loginResponse := models.LoginResponse{
-		Status: "ok",
-		Token:  token,
-		User: models.UserProfileResponse{
-			Id:      int(id),
-			Family:  family,
-			Name:    name,
-			Surname: surname,
-			IsAdmin: isAdmin,
-		},
+		Status:    "ok",
+		Token:     session.Token,
+		ExpiresAt: session.ExpiresAt,
+		CSRFToken: session.CSRFToken,
+		User:      profile,
+	}
+	if withRefresh {
+		loginResponse.RefreshToken = session.RefreshToken
+		loginResponse.RefreshExpiresAt = &session.RefreshExpiresAt
 	}
 
 	utils.WriteJSON(w, http.StatusOK, loginResponse)
 }
 
+// upgradePasswordHash re-hashes the password with the configured algorithm
+// after a successful login. It only replaces the hash that was verified, so a
+// concurrent password change is never overwritten.
+func (api *API) upgradePasswordHash(ctx context.Context, userID int64, password, oldHash string) {
+	hash, err := utils.HashPassword(strings.TrimSpace(password))
+	if err != nil {
+		fmt.Println("hash : ", err)
+		return
+	}
+	_, err = api.Pool.Exec(
+		ctx,
+		"update users set password_hash = $1 where id = $2 and password_hash = $3",
+		hash, userID, oldHash,
+	)
+	if err != nil {
+		fmt.Println("database : ", err)
+	}
+}
+
+func (api *API) rejectLogin(w http.ResponseWriter, r *http.Request, loginKey, ipKey string) {
+	err := api.recordLoginFailure(r.Context(), loginKey, api.Config.LoginMaxFailures)
+	if err == nil {
+		err = api.recordLoginFailure(r.Context(), ipKey, api.Config.LoginIPMaxFailures)
+	}
+	if err != nil {
+		fmt.Println("database : ", err)
+	}
+	utils.WriteJSONError(w, http.StatusUnauthorized, "invalid_credentials", "user does not exist or password is incorrect")
+}
+
 func (api *API) aboutMe(w http.ResponseWriter, r *http.Request) {
 	userID, ok := r.Context().Value(middlewares.UserIDKey).(int64)
 
@@ -133,7 +188,7 @@ func (api *API) aboutMe(w http.ResponseWriter, r *http.Request) {
 	var user models.UserProfileResponse
 	err := api.Pool.QueryRow(
 		r.Context(),
-		"select id, family, name, surname, is_admin from users where id = $1",
+		"select id, family, name, surname, "+isAdminColumn+" from users where id = $1",
 		userID,
 	).Scan(
 		&user.Id,
@@ -147,11 +202,29 @@ func (api *API) aboutMe(w http.ResponseWriter, r *http.Request) {
 		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to fetch user info")
 		return
 	}
+
+	if actorID, ok := r.Context().Value(middlewares.ImpersonatorIDKey).(int64); ok {
+		var actor models.UserPublicResponse
+		err = api.Pool.QueryRow(
+			r.Context(),
+			"select id, family, name, surname from users where id = $1",
+			actorID,
+		).Scan(&actor.Id, &actor.Family, &actor.Name, &actor.Surname)
+		if err != nil {
+			fmt.Println("database : ", err)
+			utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to fetch user info")
+			return
+		}
+		user.ImpersonatedBy = &actor
+	}
 	utils.WriteJSON(w, http.StatusOK, user)
 }
 
 func (api *API) logoutHandler(w http.ResponseWriter, r *http.Request) {
-	var tokenValue string
+	var (
+		tokenValue string
+		fromCookie bool
+	)
 
 	authHeader := r.Header.Get("Authorization")
 	if authHeader != "" && strings.HasPrefix(authHeader, "Bearer ") {
@@ -163,7 +236,7 @@ func (api *API) logoutHandler(w http.ResponseWriter, r *http.Request) {
 			utils.WriteJSONSuccess(w, http.StatusOK)
 			return
 		}
-		tokenValue = cookie.Value
+		tokenValue, fromCookie = cookie.Value, true
 	}
 
 	if tokenValue == "" {
@@ -172,16 +245,149 @@ func (api *API) logoutHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err := api.Pool.Exec(
-		r.Context(),
-		"delete from sessions where token_hash = $1",
-		utils.HashTokenHMAC(tokenValue),
+	// Like every other cookie-authenticated write, logging out needs the
+	// CSRF token, so another site cannot end the session.
+	if fromCookie {
+		var csrfHash *string
+		err := api.Pool.QueryRow(
+			r.Context(),
+			"select csrf_token_hash from sessions where token_hash = any($1)",
+			utils.TokenHashes(tokenValue),
+		).Scan(&csrfHash)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			utils.WriteJSONError(w, http.StatusInternalServerError, "session_delete_failed", "failed to delete session")
+			return
+		}
+		if err == nil && !middlewares.ValidCSRF(r, csrfHash) {
+			utils.WriteJSONError(w, http.StatusForbidden, "csrf_failed", "missing or invalid "+middlewares.CSRFHeader+" header")
+			return
+		}
+	}
+
+	var (
+		sessionID int64
+		userID    int64
+		actorID   *int64
 	)
-	if err != nil {
+	err := api.Pool.QueryRow(
+		r.Context(),
+		`with deleted as (
+			delete from sessions
+			where token_hash = any($1)
+			   or family_id = (select family_id from sessions where token_hash = any($1))
+			returning id, user_id, impersonator_id, token_hash = any($1) as is_current
+		 )
+		 select id, user_id, impersonator_id from deleted where is_current`,
+		utils.TokenHashes(tokenValue),
+	).Scan(&sessionID, &userID, &actorID)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
 		utils.WriteJSONError(w, http.StatusInternalServerError, "session_delete_failed", "failed to delete session")
 		return
 	}
+	if err == nil && actorID != nil {
+		api.recordImpersonationEnd(r, *actorID, userID, sessionID)
+	}
 
 	utils.ClearSessionCookie(w)
+	utils.ClearCSRFCookie(w, api.Config.CSRFCookieDomain)
 	utils.WriteJSONSuccess(w, http.StatusOK)
 }
+
+func (api *API) refreshHandler(w http.ResponseWriter, r *http.Request) {
+	var req models.RefreshRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if strings.TrimSpace(req.RefreshToken) == "" {
+		utils.WriteJSONValidationError(w, "refresh_token", "refresh_token is required")
+		return
+	}
+
+	tx, err := api.Pool.Begin(r.Context())
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to start transaction")
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	var (
+		sessionID        int64
+		userID           int64
+		familyID         string
+		refreshExpiresAt time.Time
+		refreshUsedAt    *time.Time
+	)
+	err = tx.QueryRow(
+		r.Context(),
+		`select id, user_id, family_id, refresh_expires_at, refresh_used_at
+		 from sessions where refresh_token_hash = any($1)
+		 for update`,
+		utils.TokenHashes(req.RefreshToken),
+	).Scan(&sessionID, &userID, &familyID, &refreshExpiresAt, &refreshUsedAt)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusUnauthorized, "invalid_refresh_token", "refresh token is invalid or revoked")
+		return
+	}
+
+	if refreshUsedAt != nil {
+		_, err = tx.Exec(r.Context(), "delete from sessions where family_id = $1", familyID)
+		if err == nil {
+			err = tx.Commit(r.Context())
+		}
+		if err != nil {
+			utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to revoke token family")
+			return
+		}
+		utils.WriteJSONError(w, http.StatusUnauthorized, "refresh_token_reused", "refresh token was already used, all related sessions are revoked")
+		return
+	}
+
+	now := time.Now()
+	if !now.Before(refreshExpiresAt) {
+		_, err = tx.Exec(r.Context(), "delete from sessions where family_id = $1", familyID)
+		if err == nil {
+			err = tx.Commit(r.Context())
+		}
+		if err != nil {
+			utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to delete expired session")
+			return
+		}
+		utils.WriteJSONError(w, http.StatusUnauthorized, "refresh_token_expired", "refresh token has expired")
+		return
+	}
+
+	_, err = tx.Exec(
+		r.Context(),
+		"update sessions set refresh_used_at = $1, expires_at = least(expires_at, $1) where id = $2",
+		now, sessionID,
+	)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to rotate refresh token")
+		return
+	}
+
+	opts := newSessionOptions(r)
+	opts.withRefresh = true
+	opts.familyID = familyID
+	opts.refreshExpiresAt = refreshExpiresAt
+	session, err := api.createSession(r.Context(), tx, userID, opts)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "session_save_failed", "failed to save session token")
+		return
+	}
+
+	err = tx.Commit(r.Context())
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to commit transaction")
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, models.TokenPairResponse{
+		Status:           "ok",
+		Token:            session.Token,
+		ExpiresAt:        session.ExpiresAt,
+		RefreshToken:     session.RefreshToken,
+		RefreshExpiresAt: session.RefreshExpiresAt,
+		CSRFToken:        session.CSRFToken,
+	})
+}
@@ -0,0 +1,398 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"rest-api/internal/middlewares"
+	"rest-api/internal/models"
+	"rest-api/internal/rbac"
+	"rest-api/utils"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	defaultCommentPageSize = 50
+	maxCommentPageSize     = 200
+)
+
+const notificationMention = "mention"
+
+const commentColumns = `c.id, c.task_id, c.body, c.created_at, c.updated_at,
+	u.id, u.family, u.name, u.surname`
+
+func (api *API) RegisterComments(r chi.Router) {
+	r.Group(func(gr chi.Router) {
+		gr.Use(middlewares.AuthCheck(api.Pool, api.Config))
+		gr.Use(middlewares.AddUserStatus(api.Pool, api.Config))
+
+		gr.With(api.require(rbac.TasksRead)).Get("/tasks/{id}/comments", api.getComments)
+		gr.With(api.require(rbac.TasksComment)).Post("/tasks/{id}/comments", api.createComment)
+		gr.With(api.require(rbac.TasksComment)).Patch("/tasks/{id}/comments/{commentId}", api.updateComment)
+		gr.With(api.require(rbac.TasksComment)).Delete("/tasks/{id}/comments/{commentId}", api.deleteComment)
+		gr.With(api.require(rbac.TasksRead)).Get("/tasks/{id}/comments/{commentId}/history", api.getCommentHistory)
+	})
+}
+
+func scanComment(row rowScanner) (models.CommentResponse, error) {
+	var (
+		comment models.CommentResponse
+		author  struct {
+			id      *int
+			family  *string
+			name    *string
+			surname *string
+		}
+	)
+	err := row.Scan(
+		&comment.Id,
+		&comment.TaskId,
+		&comment.Body,
+		&comment.CreatedAt,
+		&comment.UpdatedAt,
+		&author.id,
+		&author.family,
+		&author.name,
+		&author.surname,
+	)
+	if err != nil {
+		return comment, err
+	}
+	if author.id != nil {
+		comment.Author = &models.UserPublicResponse{
+			Id:      *author.id,
+			Family:  *author.family,
+			Name:    *author.name,
+			Surname: *author.surname,
+		}
+	}
+	return comment, nil
+}
+
+func (api *API) fetchComment(ctx context.Context, db dbtx, taskID, commentID int64) (models.CommentResponse, error) {
+	return scanComment(db.QueryRow(
+		ctx,
+		"select "+commentColumns+" from task_comments c left join users u on u.id = c.author_id where c.id = $1 and c.task_id = $2",
+		commentID, taskID,
+	))
+}
+
+func commentIDParam(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	commentID, err := strconv.ParseInt(chi.URLParam(r, "commentId"), 10, 64)
+	if err != nil || commentID <= 0 {
+		utils.WriteJSONError(w, http.StatusBadRequest, "invalid_comment_id", "comment id must be a positive integer")
+		return 0, false
+	}
+	return commentID, true
+}
+
+// recordMentions notifies the users mentioned in body. Only users who can see
+// the task are notified, nobody is notified about their own comment, and a
+// comment notifies each user at most once, however often it is edited.
+func (api *API) recordMentions(ctx context.Context, db dbtx, taskID, commentID, actorID int64, body string) error {
+	logins := utils.ParseMentions(body)
+	if len(logins) == 0 {
+		return nil
+	}
+	_, err := db.Exec(
+		ctx,
+		`insert into notifications(user_id, kind, actor_id, task_id, comment_id)
+		 select u.id, $1, $2, $3, $4
+		 from users u
+		 where u.login = any($5) and u.id <> $2
+		   and (`+taskVisibleTo("$3", "u.id")+`
+		        or exists(select 1 from user_roles ur
+		                  join role_permissions rp on rp.role_id = ur.role_id
+		                  where ur.user_id = u.id and rp.permission = any($6)))
+		 on conflict (user_id, kind, comment_id) do nothing`,
+		notificationMention, actorID, taskID, commentID, logins, []string{rbac.TasksReadAll, rbac.Wildcard},
+	)
+	return err
+}
+
+func (api *API) getComments(w http.ResponseWriter, r *http.Request) {
+	taskID, ok := taskIDParam(w, r)
+	if !ok || !api.checkTaskAccess(w, r, taskID) {
+		return
+	}
+
+	query := r.URL.Query()
+	limit, err := parseLimitParam(query, defaultCommentPageSize, maxCommentPageSize)
+	if err != nil {
+		writeValidationError(w, err)
+		return
+	}
+	var afterID int64
+	if value := query.Get("cursor"); value != "" {
+		after, err := decodeCursor(value)
+		if err != nil || after.Sort != "id" {
+			utils.WriteJSONValidationError(w, "cursor", "cursor is invalid")
+			return
+		}
+		afterID = after.Id
+	}
+
+	rows, err := api.Pool.Query(
+		r.Context(),
+		`select `+commentColumns+`
+		 from task_comments c
+		 left join users u on u.id = c.author_id
+		 where c.task_id = $1 and c.id > $2
+		 order by c.id
+		 limit $3`,
+		taskID, afterID, limit+1,
+	)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to fetch comments")
+		return
+	}
+	defer rows.Close()
+
+	response := models.CommentListResponse{Items: []models.CommentResponse{}}
+	for rows.Next() {
+		comment, err := scanComment(rows)
+		if err != nil {
+			utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to scan comment row")
+			return
+		}
+		if len(response.Items) == limit {
+			next := listCursor{Sort: "id", Id: response.Items[limit-1].Id}.encode()
+			response.NextCursor = &next
+			break
+		}
+		response.Items = append(response.Items, comment)
+	}
+	if rows.Err() != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to fetch comments")
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, response)
+}
+
+func (api *API) createComment(w http.ResponseWriter, r *http.Request) {
+	taskID, ok := taskIDParam(w, r)
+	if !ok || !api.checkTaskAccess(w, r, taskID) {
+		return
+	}
+	userID, _ := r.Context().Value(middlewares.UserIDKey).(int64)
+
+	var req models.CommentRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	err := utils.ValidateCommentBody(req.Body)
+	if err != nil {
+		writeValidationError(w, err)
+		return
+	}
+
+	tx, err := api.Pool.Begin(r.Context())
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to start transaction")
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	var commentID int64
+	err = tx.QueryRow(
+		r.Context(),
+		"insert into task_comments(task_id, author_id, body) values ($1, $2, $3) returning id",
+		taskID, userID, req.Body,
+	).Scan(&commentID)
+	if err == nil {
+		err = api.recordMentions(r.Context(), tx, taskID, commentID, userID, req.Body)
+	}
+	var comment models.CommentResponse
+	if err == nil {
+		comment, err = api.fetchComment(r.Context(), tx, taskID, commentID)
+	}
+	if err == nil {
+		err = tx.Commit(r.Context())
+	}
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to save comment")
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, comment)
+}
+
+// updateComment lets authors edit their own comments. The previous body is
+// kept as a revision.
+func (api *API) updateComment(w http.ResponseWriter, r *http.Request) {
+	taskID, ok := taskIDParam(w, r)
+	if !ok || !api.checkTaskAccess(w, r, taskID) {
+		return
+	}
+	commentID, ok := commentIDParam(w, r)
+	if !ok {
+		return
+	}
+	userID, _ := r.Context().Value(middlewares.UserIDKey).(int64)
+
+	var req models.CommentRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	err := utils.ValidateCommentBody(req.Body)
+	if err != nil {
+		writeValidationError(w, err)
+		return
+	}
+
+	tx, err := api.Pool.Begin(r.Context())
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to start transaction")
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	var (
+		authorID *int64
+		oldBody  string
+	)
+	err = tx.QueryRow(
+		r.Context(),
+		"select author_id, body from task_comments where id = $1 and task_id = $2 for update",
+		commentID, taskID,
+	).Scan(&authorID, &oldBody)
+	if errors.Is(err, pgx.ErrNoRows) {
+		utils.WriteJSONError(w, http.StatusNotFound, "not_found", "comment with this id does not exist")
+		return
+	}
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to fetch comment")
+		return
+	}
+	if authorID == nil || *authorID != userID {
+		utils.WriteJSONError(w, http.StatusForbidden, "forbidden", "only the author can edit a comment")
+		return
+	}
+
+	if req.Body != oldBody {
+		_, err = tx.Exec(
+			r.Context(),
+			"insert into task_comment_revisions(comment_id, body, editor_id) values ($1, $2, $3)",
+			commentID, oldBody, userID,
+		)
+		if err == nil {
+			_, err = tx.Exec(
+				r.Context(),
+				"update task_comments set body = $1, updated_at = now() where id = $2",
+				req.Body, commentID,
+			)
+		}
+		if err == nil {
+			err = api.recordMentions(r.Context(), tx, taskID, commentID, userID, req.Body)
+		}
+	}
+	var comment models.CommentResponse
+	if err == nil {
+		comment, err = api.fetchComment(r.Context(), tx, taskID, commentID)
+	}
+	if err == nil {
+		err = tx.Commit(r.Context())
+	}
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to update comment")
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, comment)
+}
+
+// deleteComment removes a comment with its history. Besides the author,
+// anyone allowed to delete the task may moderate its comments.
+func (api *API) deleteComment(w http.ResponseWriter, r *http.Request) {
+	taskID, ok := taskIDParam(w, r)
+	if !ok || !api.checkTaskAccess(w, r, taskID) {
+		return
+	}
+	commentID, ok := commentIDParam(w, r)
+	if !ok {
+		return
+	}
+	userID, _ := r.Context().Value(middlewares.UserIDKey).(int64)
+
+	var authorID *int64
+	err := api.Pool.QueryRow(
+		r.Context(),
+		"select author_id from task_comments where id = $1 and task_id = $2",
+		commentID, taskID,
+	).Scan(&authorID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		utils.WriteJSONError(w, http.StatusNotFound, "not_found", "comment with this id does not exist")
+		return
+	}
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to fetch comment")
+		return
+	}
+
+	isAuthor := authorID != nil && *authorID == userID
+	if !isAuthor && !middlewares.HasPermission(r.Context(), rbac.TasksDelete) {
+		utils.WriteJSONError(w, http.StatusForbidden, "forbidden", "only the author can delete a comment")
+		return
+	}
+
+	_, err = api.Pool.Exec(r.Context(), "delete from task_comments where id = $1", commentID)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to delete comment")
+		return
+	}
+	utils.WriteJSONSuccess(w, http.StatusOK)
+}
+
+func (api *API) getCommentHistory(w http.ResponseWriter, r *http.Request) {
+	taskID, ok := taskIDParam(w, r)
+	if !ok || !api.checkTaskAccess(w, r, taskID) {
+		return
+	}
+	commentID, ok := commentIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	var exists bool
+	err := api.Pool.QueryRow(
+		r.Context(),
+		"select exists(select 1 from task_comments where id = $1 and task_id = $2)",
+		commentID, taskID,
+	).Scan(&exists)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to fetch comment")
+		return
+	}
+	if !exists {
+		utils.WriteJSONError(w, http.StatusNotFound, "not_found", "comment with this id does not exist")
+		return
+	}
+
+	rows, err := api.Pool.Query(
+		r.Context(),
+		"select id, body, editor_id, created_at from task_comment_revisions where comment_id = $1 order by id",
+		commentID,
+	)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to fetch comment history")
+		return
+	}
+	defer rows.Close()
+
+	revisions := []models.CommentRevisionResponse{}
+	for rows.Next() {
+		var revision models.CommentRevisionResponse
+		err := rows.Scan(&revision.Id, &revision.Body, &revision.EditorId, &revision.CreatedAt)
+		if err != nil {
+			utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to scan revision row")
+			return
+		}
+		revisions = append(revisions, revision)
+	}
+
+	utils.WriteJSON(w, http.StatusOK, revisions)
+}
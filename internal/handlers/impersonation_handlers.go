@@ -0,0 +1,232 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"rest-api/internal/audit"
+	"rest-api/internal/middlewares"
+	"rest-api/internal/models"
+	"rest-api/internal/rbac"
+	"rest-api/utils"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+)
+
+const maxImpersonationReason = 500
+
+func (api *API) RegisterImpersonation(r chi.Router) {
+	r.Group(func(gr chi.Router) {
+		gr.Use(middlewares.AuthCheck(api.Pool, api.Config))
+		gr.Use(middlewares.RequireSession)
+		gr.Delete("/auth/impersonation", api.stopImpersonation)
+
+		gr.Group(func(admin chi.Router) {
+			admin.Use(middlewares.NoImpersonation)
+			admin.With(api.require(rbac.UsersImpersonate)).Post("/users/{id}/impersonate", api.startImpersonation)
+			admin.With(api.require(rbac.AuditRead)).Get("/audit-log", api.getAuditLog)
+		})
+	})
+}
+
+// startImpersonation issues a short-lived bearer token for the target user.
+// No cookie is set, so the admin's own browser session stays intact.
+func (api *API) startImpersonation(w http.ResponseWriter, r *http.Request) {
+	actorID, ok := r.Context().Value(middlewares.UserIDKey).(int64)
+	if !ok || actorID == 0 {
+		utils.WriteJSONError(w, http.StatusUnauthorized, "not_authorized", "you are not authorized")
+		return
+	}
+
+	targetID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || targetID <= 0 {
+		utils.WriteJSONError(w, http.StatusBadRequest, "invalid_id", "user id must be a positive integer")
+		return
+	}
+	if targetID == actorID {
+		utils.WriteJSONError(w, http.StatusBadRequest, "cannot_impersonate_self", "you cannot impersonate yourself")
+		return
+	}
+
+	var req models.ImpersonationRequest
+	if r.ContentLength != 0 && !readJSON(w, r, &req) {
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if len(req.Reason) > maxImpersonationReason {
+		utils.WriteJSONValidationError(w, "reason", fmt.Sprintf("reason must be at most %d characters", maxImpersonationReason))
+		return
+	}
+
+	var (
+		target        models.UserPublicResponse
+		targetIsAdmin bool
+	)
+	err = api.Pool.QueryRow(
+		r.Context(),
+		"select id, family, name, surname, "+isAdminColumn+" from users where id = $1",
+		targetID,
+	).Scan(&target.Id, &target.Family, &target.Name, &target.Surname, &targetIsAdmin)
+	if errors.Is(err, pgx.ErrNoRows) {
+		utils.WriteJSONError(w, http.StatusNotFound, "not_found", "user with this id does not exist")
+		return
+	}
+	if err != nil {
+		fmt.Println("database : ", err)
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to fetch user info")
+		return
+	}
+	if targetIsAdmin {
+		utils.WriteJSONError(w, http.StatusForbidden, "cannot_impersonate_admin", "admins cannot be impersonated")
+		return
+	}
+
+	tx, err := api.Pool.Begin(r.Context())
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to start transaction")
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	opts := newSessionOptions(r)
+	opts.impersonatorID = &actorID
+	opts.ttl = api.Config.ImpersonationTTL
+	session, err := api.createSession(r.Context(), tx, targetID, opts)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "session_save_failed", "failed to save session token")
+		return
+	}
+
+	err = audit.Record(r.Context(), tx, audit.Entry{
+		ActorID: actorID,
+		UserID:  targetID,
+		Action:  audit.ActionImpersonationStart,
+		Details: map[string]any{
+			"session_id": session.Id,
+			"reason":     req.Reason,
+			"expires_at": session.ExpiresAt,
+		},
+		IP: opts.ip,
+	})
+	if err != nil {
+		fmt.Println("database : ", err)
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to write audit log")
+		return
+	}
+
+	err = tx.Commit(r.Context())
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to commit transaction")
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, models.ImpersonationResponse{
+		Status:    "ok",
+		Token:     session.Token,
+		ExpiresAt: session.ExpiresAt,
+		User:      target,
+	})
+}
+
+func (api *API) stopImpersonation(w http.ResponseWriter, r *http.Request) {
+	actorID, ok := r.Context().Value(middlewares.ImpersonatorIDKey).(int64)
+	if !ok {
+		utils.WriteJSONError(w, http.StatusBadRequest, "not_impersonating", "this session is not an impersonation session")
+		return
+	}
+	userID, _ := r.Context().Value(middlewares.UserIDKey).(int64)
+	sessionID, _ := r.Context().Value(middlewares.SessionIDKey).(int64)
+
+	_, err := api.Pool.Exec(r.Context(), "delete from sessions where id = $1", sessionID)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "session_delete_failed", "failed to delete session")
+		return
+	}
+
+	api.recordImpersonationEnd(r, actorID, userID, sessionID)
+	utils.WriteJSONSuccess(w, http.StatusOK)
+}
+
+func (api *API) recordImpersonationEnd(r *http.Request, actorID, userID, sessionID int64) {
+	err := audit.Record(r.Context(), api.Pool, audit.Entry{
+		ActorID: actorID,
+		UserID:  userID,
+		Action:  audit.ActionImpersonationEnd,
+		Details: map[string]any{"session_id": sessionID},
+		IP:      utils.ClientIP(r),
+	})
+	if err != nil {
+		fmt.Println("audit : ", err)
+	}
+}
+
+func (api *API) getAuditLog(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	conditions := []string{"true"}
+	args := []any{}
+	for _, column := range []string{"user_id", "actor_id"} {
+		value := query.Get(column)
+		if value == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || id <= 0 {
+			utils.WriteJSONValidationError(w, column, column+" must be a positive integer")
+			return
+		}
+		args = append(args, id)
+		conditions = append(conditions, fmt.Sprintf("%s = $%d", column, len(args)))
+	}
+	if action := query.Get("action"); action != "" {
+		args = append(args, action)
+		conditions = append(conditions, fmt.Sprintf("action = $%d", len(args)))
+	}
+
+	limit := 100
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 || n > 500 {
+			utils.WriteJSONValidationError(w, "limit", "limit must be between 1 and 500")
+			return
+		}
+		limit = n
+	}
+	args = append(args, limit)
+
+	rows, err := api.Pool.Query(
+		r.Context(),
+		`select id, actor_id, user_id, action, details, ip, created_at
+		 from audit_log
+		 where `+strings.Join(conditions, " and ")+`
+		 order by id desc
+		 limit $`+strconv.Itoa(len(args)),
+		args...,
+	)
+	if err != nil {
+		fmt.Println("database : ", err)
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to fetch audit log")
+		return
+	}
+	defer rows.Close()
+
+	entries := []models.AuditLogEntry{}
+	for rows.Next() {
+		var entry models.AuditLogEntry
+		err := rows.Scan(&entry.Id, &entry.ActorId, &entry.UserId, &entry.Action, &entry.Details, &entry.IP, &entry.CreatedAt)
+		if err != nil {
+			fmt.Println("database : ", err)
+			utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to fetch audit log")
+			return
+		}
+		entries = append(entries, entry)
+	}
+	if rows.Err() != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to fetch audit log")
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, entries)
+}
@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"rest-api/internal/middlewares"
+	"rest-api/internal/models"
+	"rest-api/internal/rbac"
+	"rest-api/utils"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+)
+
+const maxInvitationNote = 500
+
+func (api *API) RegisterInvitations(r chi.Router) {
+	r.Group(func(gr chi.Router) {
+		gr.Use(middlewares.AuthCheck(api.Pool, api.Config))
+		gr.Use(api.require(rbac.UsersManage))
+		gr.Get("/invitations", api.getInvitations)
+		gr.Post("/invitations", api.createInvitation)
+		gr.Delete("/invitations/{id}", api.revokeInvitation)
+	})
+}
+
+func (api *API) getInvitations(w http.ResponseWriter, r *http.Request) {
+	rows, err := api.Pool.Query(
+		r.Context(),
+		`select i.id, ro.name, i.note, i.created_by, i.expires_at, i.used_at, i.used_by, i.created_at
+		 from invitations i
+		 join roles ro on ro.id = i.role_id
+		 order by i.id desc`,
+	)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to fetch invitations")
+		return
+	}
+	defer rows.Close()
+
+	invitations := []models.InvitationResponse{}
+	for rows.Next() {
+		var invitation models.InvitationResponse
+		err := rows.Scan(
+			&invitation.Id,
+			&invitation.Role,
+			&invitation.Note,
+			&invitation.CreatedBy,
+			&invitation.ExpiresAt,
+			&invitation.UsedAt,
+			&invitation.UsedBy,
+			&invitation.CreatedAt,
+		)
+		if err != nil {
+			utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to scan invitation row")
+			return
+		}
+		invitations = append(invitations, invitation)
+	}
+
+	utils.WriteJSON(w, http.StatusOK, invitations)
+}
+
+func (api *API) createInvitation(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middlewares.UserIDKey).(int64)
+	if !ok || userID == 0 {
+		utils.WriteJSONError(w, http.StatusUnauthorized, "not_authorized", "you are not authorized")
+		return
+	}
+
+	var req models.InvitationRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	req.Note = strings.TrimSpace(req.Note)
+	if len(req.Note) > maxInvitationNote {
+		utils.WriteJSONValidationError(w, "note", fmt.Sprintf("note must be at most %d characters", maxInvitationNote))
+		return
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(api.Config.InvitationTTL)
+	if req.ExpiresAt != nil {
+		if !req.ExpiresAt.After(now) {
+			utils.WriteJSONValidationError(w, "expires_at", "expires_at must be in the future")
+			return
+		}
+		expiresAt = *req.ExpiresAt
+	}
+
+	var (
+		roleID   int64
+		roleName string
+		err      error
+	)
+	if req.RoleId == nil {
+		err = api.Pool.QueryRow(r.Context(), "select id, name from roles where name = $1", api.Config.DefaultRole).Scan(&roleID, &roleName)
+	} else {
+		err = api.Pool.QueryRow(r.Context(), "select id, name from roles where id = $1", *req.RoleId).Scan(&roleID, &roleName)
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		utils.WriteJSONValidationError(w, "role_id", "role does not exist")
+		return
+	}
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to fetch role")
+		return
+	}
+
+	// Handing out any role other than the default one is the same as
+	// assigning it, which needs roles.manage.
+	if roleName != api.Config.DefaultRole && !middlewares.HasPermission(r.Context(), rbac.RolesManage) {
+		utils.WriteJSONError(w, http.StatusForbidden, "forbidden", "missing permission "+rbac.RolesManage)
+		return
+	}
+
+	token, err := utils.GenerateSessionToken()
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "token_generation_failed", "failed to create token")
+		return
+	}
+
+	created := models.InvitationCreatedResponse{
+		InvitationResponse: models.InvitationResponse{
+			Role:      roleName,
+			Note:      req.Note,
+			CreatedBy: &userID,
+			ExpiresAt: expiresAt,
+		},
+		Token: token,
+	}
+	err = api.Pool.QueryRow(
+		r.Context(),
+		`insert into invitations(token_hash, key_id, role_id, note, created_by, expires_at)
+		 values ($1, $2, $3, $4, $5, $6) returning id, created_at`,
+		utils.HashTokenHMAC(token), utils.CurrentKeyID(), roleID, req.Note, userID, expiresAt,
+	).Scan(&created.Id, &created.CreatedAt)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to save invitation")
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, created)
+}
+
+func (api *API) revokeInvitation(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || id <= 0 {
+		utils.WriteJSONError(w, http.StatusBadRequest, "invalid_id", "invitation id must be a positive integer")
+		return
+	}
+
+	tag, err := api.Pool.Exec(r.Context(), "delete from invitations where id = $1 and used_at is null", id)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to delete invitation")
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		utils.WriteJSONError(w, http.StatusNotFound, "not_found", "pending invitation with this id does not exist")
+		return
+	}
+	utils.WriteJSONSuccess(w, http.StatusOK)
+}
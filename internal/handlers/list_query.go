@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"rest-api/utils"
+	"strconv"
+	"strings"
+)
+
+// listCursor points at the last row of a page. It carries the sort it was
+// issued for, so it cannot be replayed against a different ordering.
+type listCursor struct {
+	Sort  string `json:"s"`
+	Desc  bool   `json:"d"`
+	Value string `json:"v"`
+	Id    int64  `json:"id"`
+}
+
+func (c listCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(raw string) (*listCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, err
+	}
+	var c listCursor
+	err = json.Unmarshal(data, &c)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func parseLimitParam(query url.Values, def, max int) (int, error) {
+	value := query.Get("limit")
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 || n > max {
+		return 0, &utils.ValidationError{Field: "limit", Message: fmt.Sprintf("limit must be between 1 and %d", max)}
+	}
+	return n, nil
+}
+
+// escapeLike escapes the LIKE wildcards in user input.
+func escapeLike(text string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return replacer.Replace(text)
+}
+
+func likePattern(text string) string {
+	return "%" + escapeLike(text) + "%"
+}
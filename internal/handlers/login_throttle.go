@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"context"
+	"time"
+)
+
+func loginAttemptKey(login string) string {
+	return "login:" + login
+}
+
+func ipAttemptKey(ip string) string {
+	return "ip:" + ip
+}
+
+func (api *API) loginBlockedUntil(ctx context.Context, keys ...string) (time.Time, error) {
+	var lockedUntil *time.Time
+	err := api.Pool.QueryRow(
+		ctx,
+		"select max(locked_until) from login_attempts where key = any($1) and locked_until > now()",
+		keys,
+	).Scan(&lockedUntil)
+	if err != nil || lockedUntil == nil {
+		return time.Time{}, err
+	}
+	return *lockedUntil, nil
+}
+
+// recordLoginFailure bumps the failure counter for key and pushes locked_until
+// forward: exponential backoff once half of maxFailures is used up, and a full
+// lockout when maxFailures is reached.
+func (api *API) recordLoginFailure(ctx context.Context, key string, maxFailures int) error {
+	var failures int
+	err := api.Pool.QueryRow(
+		ctx,
+		`insert into login_attempts(key, failures, last_failure_at) values ($1, 1, now())
+		 on conflict (key) do update set
+		   failures = case when login_attempts.last_failure_at < now() - $2::interval then 1 else login_attempts.failures + 1 end,
+		   last_failure_at = now()
+		 returning failures`,
+		key, api.Config.LoginFailureWindow,
+	).Scan(&failures)
+	if err != nil {
+		return err
+	}
+
+	var delay time.Duration
+	free := maxFailures / 2
+	switch {
+	case failures >= maxFailures:
+		delay = api.Config.LoginLockoutDuration
+	case failures > free:
+		delay = api.Config.LoginBackoffBase << (failures - free - 1)
+		if delay > api.Config.LoginBackoffMax || delay <= 0 {
+			delay = api.Config.LoginBackoffMax
+		}
+	default:
+		return nil
+	}
+
+	_, err = api.Pool.Exec(
+		ctx,
+		"update login_attempts set locked_until = $1 where key = $2",
+		time.Now().Add(delay), key,
+	)
+	return err
+}
+
+func (api *API) clearLoginFailures(ctx context.Context, key string) error {
+	_, err := api.Pool.Exec(ctx, "delete from login_attempts where key = $1", key)
+	return err
+}
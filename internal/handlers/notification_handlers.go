@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"net/http"
+	"rest-api/internal/middlewares"
+	"rest-api/internal/models"
+	"rest-api/utils"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	defaultNotificationPageSize = 50
+	maxNotificationPageSize     = 200
+)
+
+func (api *API) RegisterNotifications(r chi.Router) {
+	r.Group(func(gr chi.Router) {
+		gr.Use(middlewares.AuthCheck(api.Pool, api.Config))
+		gr.Get("/notifications", api.getNotifications)
+		gr.Post("/notifications/read-all", api.readAllNotifications)
+		gr.Post("/notifications/{id}/read", api.readNotification)
+	})
+}
+
+func (api *API) getNotifications(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middlewares.UserIDKey).(int64)
+	if !ok || userID == 0 {
+		utils.WriteJSONError(w, http.StatusUnauthorized, "not_authorized", "you are not authorized")
+		return
+	}
+
+	query := r.URL.Query()
+	limit, err := parseLimitParam(query, defaultNotificationPageSize, maxNotificationPageSize)
+	if err != nil {
+		writeValidationError(w, err)
+		return
+	}
+	unreadOnly := false
+	if value := query.Get("unread"); value != "" {
+		unreadOnly, err = strconv.ParseBool(value)
+		if err != nil {
+			utils.WriteJSONValidationError(w, "unread", "unread must be a boolean")
+			return
+		}
+	}
+
+	rows, err := api.Pool.Query(
+		r.Context(),
+		`select id, kind, actor_id, task_id, comment_id, created_at, read_at
+		 from notifications
+		 where user_id = $1 and (not $2 or read_at is null)
+		 order by id desc
+		 limit $3`,
+		userID, unreadOnly, limit,
+	)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to fetch notifications")
+		return
+	}
+	defer rows.Close()
+
+	notifications := []models.NotificationResponse{}
+	for rows.Next() {
+		var n models.NotificationResponse
+		err := rows.Scan(&n.Id, &n.Kind, &n.ActorId, &n.TaskId, &n.CommentId, &n.CreatedAt, &n.ReadAt)
+		if err != nil {
+			utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to scan notification row")
+			return
+		}
+		notifications = append(notifications, n)
+	}
+
+	utils.WriteJSON(w, http.StatusOK, notifications)
+}
+
+func (api *API) readNotification(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middlewares.UserIDKey).(int64)
+	if !ok || userID == 0 {
+		utils.WriteJSONError(w, http.StatusUnauthorized, "not_authorized", "you are not authorized")
+		return
+	}
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || id <= 0 {
+		utils.WriteJSONError(w, http.StatusBadRequest, "invalid_id", "id must be a positive integer")
+		return
+	}
+
+	tag, err := api.Pool.Exec(
+		r.Context(),
+		"update notifications set read_at = coalesce(read_at, now()) where id = $1 and user_id = $2",
+		id, userID,
+	)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to update notification")
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		utils.WriteJSONError(w, http.StatusNotFound, "not_found", "notification with this id does not exist")
+		return
+	}
+	utils.WriteJSONSuccess(w, http.StatusOK)
+}
+
+func (api *API) readAllNotifications(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middlewares.UserIDKey).(int64)
+	if !ok || userID == 0 {
+		utils.WriteJSONError(w, http.StatusUnauthorized, "not_authorized", "you are not authorized")
+		return
+	}
+
+	_, err := api.Pool.Exec(
+		r.Context(),
+		"update notifications set read_at = now() where user_id = $1 and read_at is null",
+		userID,
+	)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to update notifications")
+		return
+	}
+	utils.WriteJSONSuccess(w, http.StatusOK)
+}
@@ -0,0 +1,423 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"rest-api/config"
+	"rest-api/internal/middlewares"
+	"rest-api/internal/models"
+	"rest-api/internal/oidc"
+	"rest-api/utils"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	errLoginTaken         = errors.New("login is already taken")
+	errDefaultRoleMissing = errors.New("default role does not exist")
+)
+
+func (api *API) RegisterOIDC(r chi.Router) {
+	r.Group(func(gr chi.Router) {
+		gr.Use(middlewares.AuthCheck(api.Pool, api.Config))
+		gr.Use(middlewares.RequireSession)
+		gr.Use(middlewares.NoImpersonation)
+		gr.Post("/auth/oidc/link", api.oidcLinkHandler)
+		gr.Get("/auth/oidc/identities", api.getIdentities)
+		gr.Delete("/auth/oidc/identities/{id}", api.unlinkIdentity)
+	})
+	r.Get("/auth/oidc/login", api.oidcLoginHandler)
+	r.Get("/auth/oidc/callback", api.oidcCallbackHandler)
+}
+
+func (api *API) oidcEnabled(w http.ResponseWriter) bool {
+	if api.OIDC == nil {
+		utils.WriteJSONError(w, http.StatusNotFound, "oidc_disabled", "external sign-in is not configured")
+		return false
+	}
+	return true
+}
+
+// startOIDCFlow stores the PKCE verifier and nonce server side and returns the
+// provider URL the browser has to visit. The state is also set as a cookie,
+// so only the browser that started the flow can finish it. linkUserID is set
+// when an already signed-in user attaches an external identity to the account.
+func (api *API) startOIDCFlow(w http.ResponseWriter, r *http.Request, linkUserID *int64) (string, error) {
+	ctx := r.Context()
+	state, err := utils.GenerateSessionToken()
+	if err != nil {
+		return "", err
+	}
+	verifier, err := oidc.GenerateCodeVerifier()
+	if err != nil {
+		return "", err
+	}
+	nonce, err := oidc.GenerateNonce()
+	if err != nil {
+		return "", err
+	}
+
+	authURL, err := api.OIDC.AuthCodeURL(ctx, state, nonce, oidc.CodeChallengeS256(verifier))
+	if err != nil {
+		return "", err
+	}
+
+	expiresAt := time.Now().Add(api.Config.OIDCStateTTL)
+	_, err = api.Pool.Exec(
+		ctx,
+		`insert into oidc_states(state_hash, key_id, code_verifier, nonce, link_user_id, expires_at)
+		 values ($1, $2, $3, $4, $5, $6)`,
+		utils.HashTokenHMAC(state), utils.CurrentKeyID(), verifier, nonce, linkUserID, expiresAt,
+	)
+	if err != nil {
+		return "", err
+	}
+	utils.SetOIDCStateCookie(w, state, expiresAt)
+	return authURL, nil
+}
+
+func (api *API) oidcLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if !api.oidcEnabled(w) {
+		return
+	}
+
+	authURL, err := api.startOIDCFlow(w, r, nil)
+	if err != nil {
+		fmt.Println("oidc : ", err)
+		utils.WriteJSONError(w, http.StatusBadGateway, "oidc_unavailable", "failed to start external sign-in")
+		return
+	}
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+func (api *API) oidcLinkHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middlewares.UserIDKey).(int64)
+	if !ok || userID == 0 {
+		utils.WriteJSONError(w, http.StatusUnauthorized, "not_authorized", "you are not authorized")
+		return
+	}
+	if !api.oidcEnabled(w) {
+		return
+	}
+
+	authURL, err := api.startOIDCFlow(w, r, &userID)
+	if err != nil {
+		fmt.Println("oidc : ", err)
+		utils.WriteJSONError(w, http.StatusBadGateway, "oidc_unavailable", "failed to start external sign-in")
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, models.AuthorizationURLResponse{AuthorizationURL: authURL})
+}
+
+func (api *API) oidcCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if !api.oidcEnabled(w) {
+		return
+	}
+
+	query := r.URL.Query()
+	if providerErr := query.Get("error"); providerErr != "" {
+		utils.WriteJSONError(w, http.StatusUnauthorized, "oidc_error", providerErr+": "+query.Get("error_description"))
+		return
+	}
+	state := query.Get("state")
+	code := query.Get("code")
+	if state == "" || code == "" {
+		utils.WriteJSONError(w, http.StatusBadRequest, "invalid_request", "state and code are required")
+		return
+	}
+	cookie, err := r.Cookie(utils.OIDCStateCookie)
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		utils.WriteJSONError(w, http.StatusBadRequest, "invalid_state", "sign-in was started in another browser")
+		return
+	}
+	utils.ClearOIDCStateCookie(w)
+
+	var (
+		verifier   string
+		nonce      string
+		linkUserID *int64
+	)
+	err = api.Pool.QueryRow(
+		r.Context(),
+		`delete from oidc_states
+		 where state_hash = any($1) and expires_at > now()
+		 returning code_verifier, nonce, link_user_id`,
+		utils.TokenHashes(state),
+	).Scan(&verifier, &nonce, &linkUserID)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusBadRequest, "invalid_state", "sign-in request is unknown or expired")
+		return
+	}
+
+	token, err := api.OIDC.Exchange(r.Context(), code, verifier)
+	if err != nil {
+		fmt.Println("oidc : ", err)
+		utils.WriteJSONError(w, http.StatusBadGateway, "oidc_exchange_failed", "failed to exchange authorization code")
+		return
+	}
+
+	claims, err := api.OIDC.VerifyIDToken(r.Context(), token.IDToken, nonce)
+	if err != nil {
+		fmt.Println("oidc : ", err)
+		utils.WriteJSONError(w, http.StatusUnauthorized, "invalid_id_token", "identity provider returned an invalid token")
+		return
+	}
+
+	if linkUserID != nil {
+		api.linkIdentity(w, r, *linkUserID, claims)
+		return
+	}
+
+	var userID int64
+	err = api.Pool.QueryRow(
+		r.Context(),
+		"update user_identities set last_login_at = now() where issuer = $1 and subject = $2 returning user_id",
+		claims.Issuer, claims.Subject,
+	).Scan(&userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		if !api.Config.OIDCAutoProvision || api.Config.RegistrationPolicy != config.RegistrationOpen {
+			utils.WriteJSONError(w, http.StatusForbidden, "account_not_linked", "no account is linked to this external identity")
+			return
+		}
+		userID, err = api.provisionOIDCUser(r.Context(), claims)
+		if errors.Is(err, errLoginTaken) {
+			utils.WriteJSONError(w, http.StatusConflict, "login_taken", "an account with this login already exists, sign in and link it instead")
+			return
+		}
+		if errors.Is(err, errDefaultRoleMissing) {
+			utils.WriteJSONError(w, http.StatusInternalServerError, "role_missing", "the role for new users does not exist")
+			return
+		}
+	}
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to resolve external identity")
+		return
+	}
+
+	var (
+		profile     models.UserProfileResponse
+		totpEnabled bool
+	)
+	err = api.Pool.QueryRow(
+		r.Context(),
+		"select id, family, name, surname, "+isAdminColumn+", totp_enabled from users where id = $1",
+		userID,
+	).Scan(
+		&profile.Id,
+		&profile.Family,
+		&profile.Name,
+		&profile.Surname,
+		&profile.IsAdmin,
+		&totpEnabled,
+	)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to fetch user info")
+		return
+	}
+
+	if totpEnabled {
+		api.startTwoFactorChallenge(w, r, userID, false)
+		return
+	}
+	api.issueLogin(w, r, profile, false)
+}
+
+func (api *API) linkIdentity(w http.ResponseWriter, r *http.Request, userID int64, claims *oidc.Claims) {
+	var owner int64
+	err := api.Pool.QueryRow(
+		r.Context(),
+		`insert into user_identities(user_id, issuer, subject, email)
+		 values ($1, $2, $3, $4)
+		 on conflict (issuer, subject) do update
+		   set email = case when user_identities.user_id = excluded.user_id then excluded.email else user_identities.email end
+		 returning user_id`,
+		userID, claims.Issuer, claims.Subject, claims.Email,
+	).Scan(&owner)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to link identity")
+		return
+	}
+	if owner != userID {
+		utils.WriteJSONError(w, http.StatusConflict, "identity_linked", "this external identity is linked to another account")
+		return
+	}
+	utils.WriteJSONSuccess(w, http.StatusOK)
+}
+
+func oidcLogin(claims *oidc.Claims) string {
+	switch {
+	case claims.PreferredUsername != "":
+		return claims.PreferredUsername
+	case claims.Email != "":
+		return claims.Email
+	default:
+		return "oidc-" + claims.Subject
+	}
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if strings.TrimSpace(v) != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func (api *API) provisionOIDCUser(ctx context.Context, claims *oidc.Claims) (int64, error) {
+	login := oidcLogin(claims)
+	name := firstNonEmpty(claims.GivenName, claims.Name, login)
+	family := firstNonEmpty(claims.FamilyName, name)
+	surname := firstNonEmpty(claims.MiddleName, "-")
+
+	tx, err := api.Pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	var userID int64
+	err = tx.QueryRow(
+		ctx,
+		`insert into users (login, family, name, surname, password_hash, is_admin)
+		 values ($1, $2, $3, $4, $5, false)
+		 on conflict (login) do nothing
+		 returning id`,
+		login, family, name, surname, utils.UnusablePasswordHash,
+	).Scan(&userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, errLoginTaken
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	tag, err := tx.Exec(
+		ctx,
+		"insert into user_roles(user_id, role_id) select $1, id from roles where name = $2",
+		userID, api.Config.DefaultRole,
+	)
+	if err != nil {
+		return 0, err
+	}
+	if tag.RowsAffected() == 0 {
+		return 0, errDefaultRoleMissing
+	}
+
+	_, err = tx.Exec(
+		ctx,
+		"insert into user_identities(user_id, issuer, subject, email, last_login_at) values ($1, $2, $3, $4, now())",
+		userID, claims.Issuer, claims.Subject, claims.Email,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return userID, tx.Commit(ctx)
+}
+
+func (api *API) getIdentities(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middlewares.UserIDKey).(int64)
+	if !ok || userID == 0 {
+		utils.WriteJSONError(w, http.StatusUnauthorized, "not_authorized", "you are not authorized")
+		return
+	}
+
+	rows, err := api.Pool.Query(
+		r.Context(),
+		"select id, issuer, subject, coalesce(email, ''), created_at, last_login_at from user_identities where user_id = $1 order by id",
+		userID,
+	)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to fetch identities")
+		return
+	}
+	defer rows.Close()
+
+	identities := []models.IdentityResponse{}
+	for rows.Next() {
+		var identity models.IdentityResponse
+		err := rows.Scan(
+			&identity.Id,
+			&identity.Issuer,
+			&identity.Subject,
+			&identity.Email,
+			&identity.CreatedAt,
+			&identity.LastLoginAt,
+		)
+		if err != nil {
+			utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to scan identity row")
+			return
+		}
+		identities = append(identities, identity)
+	}
+
+	utils.WriteJSON(w, http.StatusOK, identities)
+}
+
+func (api *API) unlinkIdentity(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middlewares.UserIDKey).(int64)
+	if !ok || userID == 0 {
+		utils.WriteJSONError(w, http.StatusUnauthorized, "not_authorized", "you are not authorized")
+		return
+	}
+
+	identityID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || identityID <= 0 {
+		utils.WriteJSONError(w, http.StatusBadRequest, "invalid_identity_id", "identity id must be a positive integer")
+		return
+	}
+
+	tx, err := api.Pool.Begin(r.Context())
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to start transaction")
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	// An account without a local password must keep at least one identity,
+	// otherwise nobody could ever sign in to it again.
+	var (
+		passwordHash  string
+		identityCount int
+	)
+	err = tx.QueryRow(
+		r.Context(),
+		`select password_hash, (select count(*) from user_identities where user_id = users.id)
+		 from users where id = $1
+		 for update`,
+		userID,
+	).Scan(&passwordHash, &identityCount)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to fetch user info")
+		return
+	}
+	if passwordHash == utils.UnusablePasswordHash && identityCount <= 1 {
+		utils.WriteJSONError(w, http.StatusConflict, "last_identity", "set a password before removing the last external identity")
+		return
+	}
+
+	tag, err := tx.Exec(r.Context(), "delete from user_identities where id = $1 and user_id = $2", identityID, userID)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to unlink identity")
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		utils.WriteJSONError(w, http.StatusNotFound, "not_found", "identity with this id does not exist")
+		return
+	}
+
+	err = tx.Commit(r.Context())
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to commit transaction")
+		return
+	}
+	utils.WriteJSONSuccess(w, http.StatusOK)
+}
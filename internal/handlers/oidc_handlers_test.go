@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"rest-api/config"
+	"rest-api/internal/oidc"
+	"rest-api/utils"
+	"strings"
+	"testing"
+)
+
+// The state cookie is checked before anything touches the database, so the
+// API needs no pool here.
+func TestOIDCCallbackRequiresStateCookie(t *testing.T) {
+	api := &API{
+		Config: &config.Config{},
+		OIDC:   oidc.NewProvider(oidc.Config{Issuer: "http://idp.invalid", ClientID: "rest-api"}),
+	}
+
+	tests := []struct {
+		name   string
+		cookie string
+	}{
+		{name: "no cookie"},
+		{name: "other state", cookie: "state-of-another-flow"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/auth/oidc/callback?state=attacker-state&code=code", nil)
+			if tt.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: utils.OIDCStateCookie, Value: tt.cookie})
+			}
+			w := httptest.NewRecorder()
+			api.oidcCallbackHandler(w, r)
+
+			if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "invalid_state") {
+				t.Fatalf("got %d %s, want 400 invalid_state", w.Code, w.Body.String())
+			}
+		})
+	}
+}
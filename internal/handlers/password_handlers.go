@@ -0,0 +1,250 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"rest-api/internal/middlewares"
+	"rest-api/internal/models"
+	"rest-api/internal/notify"
+	"rest-api/utils"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func (api *API) RegisterPassword(r chi.Router) {
+	r.Group(func(gr chi.Router) {
+		gr.Use(middlewares.AuthCheck(api.Pool, api.Config))
+		gr.Use(middlewares.RequireSession)
+		gr.Use(middlewares.NoImpersonation)
+		gr.Put("/auth/password", api.changePasswordHandler)
+	})
+	r.Post("/auth/password/forgot", api.forgotPasswordHandler)
+	r.Post("/auth/password/reset", api.resetPasswordHandler)
+}
+
+func (api *API) changePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middlewares.UserIDKey).(int64)
+	if !ok || userID == 0 {
+		utils.WriteJSONError(w, http.StatusUnauthorized, "not_authorized", "you are not authorized")
+		return
+	}
+	sessionID, _ := r.Context().Value(middlewares.SessionIDKey).(int64)
+
+	var req models.PasswordChangeRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if strings.TrimSpace(req.CurrentPassword) == "" {
+		utils.WriteJSONValidationError(w, "current_password", "current_password is required")
+		return
+	}
+	// ComparePassword trims what it checks, so the new password is hashed
+	// the same way or it could never be used to sign in.
+	req.NewPassword = strings.TrimSpace(req.NewPassword)
+	err := utils.ValidatePassword("new_password", req.NewPassword)
+	if err != nil {
+		writeValidationError(w, err)
+		return
+	}
+
+	var login, passwordHash string
+	err = api.Pool.QueryRow(
+		r.Context(),
+		"select login, password_hash from users where id = $1",
+		userID,
+	).Scan(&login, &passwordHash)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to fetch user info")
+		return
+	}
+
+	// Guessing the current password here counts against the same lockout as
+	// guessing it at /auth/login.
+	loginKey := loginAttemptKey(login)
+	lockedUntil, err := api.loginBlockedUntil(r.Context(), loginKey)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to check login attempts")
+		return
+	}
+	if !lockedUntil.IsZero() {
+		utils.WriteJSONRetryAfter(w, time.Until(lockedUntil), "too_many_attempts", "too many failed password attempts, try again later")
+		return
+	}
+
+	err = utils.ComparePassword(req.CurrentPassword, passwordHash)
+	if err != nil {
+		err = api.recordLoginFailure(r.Context(), loginKey, api.Config.LoginMaxFailures)
+		if err != nil {
+			fmt.Println("database : ", err)
+		}
+		utils.WriteJSONError(w, http.StatusUnauthorized, "invalid_credentials", "current password is incorrect")
+		return
+	}
+
+	hash, err := utils.HashPassword(req.NewPassword)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "hash_error", "failed to hash password")
+		return
+	}
+
+	tx, err := api.Pool.Begin(r.Context())
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to start transaction")
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	_, err = tx.Exec(r.Context(), "update users set password_hash = $1 where id = $2", hash, userID)
+	if err == nil {
+		_, err = tx.Exec(r.Context(), "delete from sessions where user_id = $1 and id <> $2", userID, sessionID)
+	}
+	if err == nil {
+		_, err = tx.Exec(r.Context(), "delete from login_attempts where key = $1", loginKey)
+	}
+	if err == nil {
+		err = tx.Commit(r.Context())
+	}
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to change password")
+		return
+	}
+
+	utils.WriteJSONSuccess(w, http.StatusOK)
+}
+
+func (api *API) forgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var req models.PasswordForgotRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if strings.TrimSpace(req.Login) == "" {
+		utils.WriteJSONValidationError(w, "login", "login is required")
+		return
+	}
+
+	// The response is the same whether the login exists or not, so the
+	// endpoint cannot be used to enumerate accounts.
+	var userID int64
+	err := api.Pool.QueryRow(
+		r.Context(),
+		"select id from users where login = $1",
+		req.Login,
+	).Scan(&userID)
+	if err != nil {
+		utils.WriteJSONSuccess(w, http.StatusAccepted)
+		return
+	}
+
+	token, err := utils.GenerateSessionToken()
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "token_generation_failed", "failed to create token")
+		return
+	}
+	expiresAt := time.Now().Add(api.Config.PasswordResetTTL)
+
+	tx, err := api.Pool.Begin(r.Context())
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to start transaction")
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	_, err = tx.Exec(r.Context(), "delete from password_resets where user_id = $1 and used_at is null", userID)
+	if err == nil {
+		_, err = tx.Exec(
+			r.Context(),
+			"insert into password_resets(user_id, token_hash, key_id, expires_at) values ($1, $2, $3, $4)",
+			userID, utils.HashTokenHMAC(token), utils.CurrentKeyID(), expiresAt,
+		)
+	}
+	if err == nil {
+		err = tx.Commit(r.Context())
+	}
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to save reset token")
+		return
+	}
+
+	err = api.Notifier.SendPasswordReset(r.Context(), notify.PasswordReset{
+		UserID:    userID,
+		Login:     req.Login,
+		Token:     token,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		fmt.Println("notifier : ", err)
+	}
+
+	utils.WriteJSONSuccess(w, http.StatusAccepted)
+}
+
+func (api *API) resetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var req models.PasswordResetRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if strings.TrimSpace(req.Token) == "" {
+		utils.WriteJSONValidationError(w, "token", "token is required")
+		return
+	}
+	req.NewPassword = strings.TrimSpace(req.NewPassword)
+	err := utils.ValidatePassword("new_password", req.NewPassword)
+	if err != nil {
+		writeValidationError(w, err)
+		return
+	}
+
+	tx, err := api.Pool.Begin(r.Context())
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to start transaction")
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	var (
+		resetID int64
+		userID  int64
+		login   string
+	)
+	err = tx.QueryRow(
+		r.Context(),
+		`select pr.id, pr.user_id, u.login
+		 from password_resets pr
+		 join users u on u.id = pr.user_id
+		 where pr.token_hash = any($1) and pr.used_at is null and pr.expires_at > now()
+		 for update of pr`,
+		utils.TokenHashes(req.Token),
+	).Scan(&resetID, &userID, &login)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusBadRequest, "invalid_reset_token", "reset token is invalid or expired")
+		return
+	}
+
+	hash, err := utils.HashPassword(req.NewPassword)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "hash_error", "failed to hash password")
+		return
+	}
+
+	_, err = tx.Exec(r.Context(), "update users set password_hash = $1 where id = $2", hash, userID)
+	if err == nil {
+		_, err = tx.Exec(r.Context(), "update password_resets set used_at = now() where id = $1", resetID)
+	}
+	if err == nil {
+		_, err = tx.Exec(r.Context(), "delete from sessions where user_id = $1", userID)
+	}
+	if err == nil {
+		_, err = tx.Exec(r.Context(), "delete from login_attempts where key = $1", loginAttemptKey(login))
+	}
+	if err == nil {
+		err = tx.Commit(r.Context())
+	}
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to reset password")
+		return
+	}
+
+	utils.WriteJSONSuccess(w, http.StatusOK)
+}
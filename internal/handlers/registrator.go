@@ -8,5 +8,16 @@ func (api *API) RegisterAll(c chi.Router) {
 	api.RegisterRoot(c)
 	api.RegisterUserMethods(c)
 	api.RegisterAuth(c)
+	api.RegisterSessions(c)
+	api.RegisterTwoFactor(c)
+	api.RegisterPassword(c)
+	api.RegisterRoles(c)
+	api.RegisterAPIKeys(c)
+	api.RegisterOIDC(c)
+	api.RegisterImpersonation(c)
+	api.RegisterInvitations(c)
 	api.RegisterTasks(c)
+	api.RegisterComments(c)
+	api.RegisterAttachments(c)
+	api.RegisterNotifications(c)
 }
@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"rest-api/utils"
+)
+
+func readJSON(w http.ResponseWriter, r *http.Request, dst any) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, 1<<20)
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusBadRequest, "invalid_request", "failed to read body")
+		return false
+	}
+	err = json.Unmarshal(body, dst)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusBadRequest, "invalid_json", "request body is not valid JSON")
+		return false
+	}
+	return true
+}
+
+func writeValidationError(w http.ResponseWriter, err error) {
+	valErr, ok := err.(*utils.ValidationError)
+	if ok {
+		utils.WriteJSONValidationError(w, valErr.Field, valErr.Message)
+	} else {
+		utils.WriteJSONError(w, http.StatusBadRequest, "validation_error", err.Error())
+	}
+}
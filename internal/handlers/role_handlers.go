@@ -0,0 +1,388 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"rest-api/internal/middlewares"
+	"rest-api/internal/models"
+	"rest-api/internal/rbac"
+	"rest-api/utils"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+)
+
+// isAdminColumn reports admin status for a query over the users table. The
+// old users.is_admin column is no longer read, the admin role is the source.
+const isAdminColumn = `exists(select 1 from user_roles ur join roles ro on ro.id = ur.role_id
+	where ur.user_id = users.id and ro.name = 'admin')`
+
+// privilegedColumn tells whether a user holds a role that grants the
+// wildcard or a privileged permission, which REQUIRE_2FA_FOR_ADMINS covers.
+var privilegedColumn = `exists(select 1 from user_roles ur join role_permissions rp on rp.role_id = ur.role_id
+	where ur.user_id = users.id and rp.permission in ('` +
+	strings.Join(append([]string{rbac.Wildcard}, rbac.Privileged...), "', '") + `'))`
+
+func (api *API) require(permission string) func(http.Handler) http.Handler {
+	return middlewares.RequirePermission(api.Pool, api.Config, permission)
+}
+
+func (api *API) RegisterRoles(r chi.Router) {
+	r.Group(func(gr chi.Router) {
+		gr.Use(middlewares.AuthCheck(api.Pool, api.Config))
+		gr.Use(api.require(rbac.RolesManage))
+
+		gr.Get("/permissions", api.getPermissions)
+		gr.Get("/roles", api.getRoles)
+		gr.Post("/roles", api.createRole)
+		gr.Put("/roles/{id}", api.updateRole)
+		gr.Delete("/roles/{id}", api.deleteRole)
+
+		gr.Get("/users/{id}/roles", api.getUserRoles)
+		gr.Post("/users/{id}/roles", api.grantUserRole)
+		gr.Delete("/users/{id}/roles/{roleId}", api.revokeUserRole)
+	})
+}
+
+func validateRoleRequest(req *models.RoleRequest) error {
+	if strings.TrimSpace(req.Name) == "" {
+		return &utils.ValidationError{Field: "name", Message: "name is required"}
+	}
+	for _, p := range req.Permissions {
+		if !rbac.IsKnown(p) {
+			return &utils.ValidationError{Field: "permissions", Message: "unknown permission " + p}
+		}
+	}
+	return nil
+}
+
+func (api *API) fetchRoles(ctx context.Context, query string, args ...any) ([]models.RoleResponse, error) {
+	rows, err := api.Pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []models.RoleResponse{}
+	for rows.Next() {
+		role := models.RoleResponse{}
+		err := rows.Scan(
+			&role.Id,
+			&role.Name,
+			&role.Description,
+			&role.Builtin,
+			&role.Permissions,
+		)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	return roles, rows.Err()
+}
+
+const roleSelect = `select ro.id, ro.name, ro.description, ro.builtin,
+	coalesce(array_agg(rp.permission order by rp.permission) filter (where rp.permission is not null), '{}')
+	from roles ro
+	left join role_permissions rp on rp.role_id = ro.id`
+
+func (api *API) getPermissions(w http.ResponseWriter, r *http.Request) {
+	utils.WriteJSON(w, http.StatusOK, rbac.AllPermissions)
+}
+
+func (api *API) getRoles(w http.ResponseWriter, r *http.Request) {
+	roles, err := api.fetchRoles(r.Context(), roleSelect+" group by ro.id order by ro.id")
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to fetch roles")
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, roles)
+}
+
+func (api *API) setRolePermissions(ctx context.Context, tx pgx.Tx, roleID int64, permissions []string) error {
+	_, err := tx.Exec(ctx, "delete from role_permissions where role_id = $1", roleID)
+	if err != nil {
+		return err
+	}
+	for _, p := range permissions {
+		_, err = tx.Exec(
+			ctx,
+			"insert into role_permissions(role_id, permission) values ($1, $2) on conflict do nothing",
+			roleID, p,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (api *API) createRole(w http.ResponseWriter, r *http.Request) {
+	var req models.RoleRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	err := validateRoleRequest(&req)
+	if err != nil {
+		writeValidationError(w, err)
+		return
+	}
+
+	tx, err := api.Pool.Begin(r.Context())
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to start transaction")
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	var roleID int64
+	err = tx.QueryRow(
+		r.Context(),
+		"insert into roles(name, description) values ($1, $2) on conflict (name) do nothing returning id",
+		strings.TrimSpace(req.Name), req.Description,
+	).Scan(&roleID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		utils.WriteJSONError(w, http.StatusConflict, "role_is_exist", "role with this name already exists")
+		return
+	}
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to create role")
+		return
+	}
+
+	err = api.setRolePermissions(r.Context(), tx, roleID, req.Permissions)
+	if err == nil {
+		err = tx.Commit(r.Context())
+	}
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to save role permissions")
+		return
+	}
+
+	utils.WriteJSONSuccess(w, http.StatusCreated)
+}
+
+func (api *API) updateRole(w http.ResponseWriter, r *http.Request) {
+	roleID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || roleID <= 0 {
+		utils.WriteJSONError(w, http.StatusBadRequest, "invalid_role_id", "role id must be a positive integer")
+		return
+	}
+
+	var req models.RoleRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	tx, err := api.Pool.Begin(r.Context())
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to start transaction")
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	var (
+		name    string
+		builtin bool
+	)
+	err = tx.QueryRow(r.Context(), "select name, builtin from roles where id = $1 for update", roleID).Scan(&name, &builtin)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusNotFound, "not_found", "role with this id does not exist")
+		return
+	}
+	if name == rbac.AdminRole {
+		utils.WriteJSONError(w, http.StatusForbidden, "forbidden", "the admin role cannot be modified")
+		return
+	}
+
+	if strings.TrimSpace(req.Name) == "" {
+		req.Name = name
+	}
+	if strings.TrimSpace(req.Name) != name && (builtin || name == api.Config.DefaultRole) {
+		utils.WriteJSONError(w, http.StatusForbidden, "forbidden", "built-in roles and the default role cannot be renamed")
+		return
+	}
+	err = validateRoleRequest(&req)
+	if err != nil {
+		writeValidationError(w, err)
+		return
+	}
+
+	_, err = tx.Exec(
+		r.Context(),
+		"update roles set name = $1, description = $2 where id = $3",
+		strings.TrimSpace(req.Name), req.Description, roleID,
+	)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusConflict, "role_is_exist", "role with this name already exists")
+		return
+	}
+
+	err = api.setRolePermissions(r.Context(), tx, roleID, req.Permissions)
+	if err == nil {
+		err = tx.Commit(r.Context())
+	}
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to save role permissions")
+		return
+	}
+
+	utils.WriteJSONSuccess(w, http.StatusOK)
+}
+
+func (api *API) deleteRole(w http.ResponseWriter, r *http.Request) {
+	roleID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || roleID <= 0 {
+		utils.WriteJSONError(w, http.StatusBadRequest, "invalid_role_id", "role id must be a positive integer")
+		return
+	}
+
+	var (
+		name    string
+		builtin bool
+	)
+	err = api.Pool.QueryRow(r.Context(), "select name, builtin from roles where id = $1", roleID).Scan(&name, &builtin)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusNotFound, "not_found", "role with this id does not exist")
+		return
+	}
+	if builtin || name == api.Config.DefaultRole {
+		utils.WriteJSONError(w, http.StatusForbidden, "forbidden", "built-in roles and the default role cannot be deleted")
+		return
+	}
+
+	_, err = api.Pool.Exec(r.Context(), "delete from roles where id = $1", roleID)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to delete role")
+		return
+	}
+	utils.WriteJSONSuccess(w, http.StatusOK)
+}
+
+func (api *API) getUserRoles(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || userID <= 0 {
+		utils.WriteJSONError(w, http.StatusBadRequest, "invalid_id", "user id must be a positive integer")
+		return
+	}
+
+	roles, err := api.fetchRoles(
+		r.Context(),
+		roleSelect+" join user_roles ur on ur.role_id = ro.id where ur.user_id = $1 group by ro.id order by ro.id",
+		userID,
+	)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to fetch roles")
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, roles)
+}
+
+func (api *API) grantUserRole(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || userID <= 0 {
+		utils.WriteJSONError(w, http.StatusBadRequest, "invalid_id", "user id must be a positive integer")
+		return
+	}
+
+	var req models.UserRoleRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if req.RoleId <= 0 {
+		utils.WriteJSONValidationError(w, "role_id", "role_id must be a positive integer")
+		return
+	}
+
+	var userExists, roleExists bool
+	err = api.Pool.QueryRow(
+		r.Context(),
+		"select exists(select 1 from users where id = $1), exists(select 1 from roles where id = $2)",
+		userID, req.RoleId,
+	).Scan(&userExists, &roleExists)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to check user and role")
+		return
+	}
+	if !userExists {
+		utils.WriteJSONError(w, http.StatusNotFound, "not_found", "user with this id does not exist")
+		return
+	}
+	if !roleExists {
+		utils.WriteJSONError(w, http.StatusNotFound, "not_found", "role with this id does not exist")
+		return
+	}
+
+	_, err = api.Pool.Exec(
+		r.Context(),
+		"insert into user_roles(user_id, role_id) values ($1, $2) on conflict do nothing",
+		userID, req.RoleId,
+	)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to grant role")
+		return
+	}
+	utils.WriteJSONSuccess(w, http.StatusOK)
+}
+
+func (api *API) revokeUserRole(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || userID <= 0 {
+		utils.WriteJSONError(w, http.StatusBadRequest, "invalid_id", "user id must be a positive integer")
+		return
+	}
+	roleID, err := strconv.ParseInt(chi.URLParam(r, "roleId"), 10, 64)
+	if err != nil || roleID <= 0 {
+		utils.WriteJSONError(w, http.StatusBadRequest, "invalid_role_id", "role id must be a positive integer")
+		return
+	}
+
+	tx, err := api.Pool.Begin(r.Context())
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to start transaction")
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	// Lock the admin role row so two concurrent revokes cannot both pass the
+	// last-admin check.
+	var (
+		roleName   string
+		adminCount int
+	)
+	err = tx.QueryRow(
+		r.Context(),
+		`select ro.name, (select count(*) from user_roles ur join roles a on a.id = ur.role_id where a.name = $2)
+		 from roles ro where ro.id = $1
+		 for update`,
+		roleID, rbac.AdminRole,
+	).Scan(&roleName, &adminCount)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusNotFound, "not_found", "role with this id does not exist")
+		return
+	}
+
+	tag, err := tx.Exec(r.Context(), "delete from user_roles where user_id = $1 and role_id = $2", userID, roleID)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to revoke role")
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		utils.WriteJSONError(w, http.StatusNotFound, "not_found", "user does not have this role")
+		return
+	}
+	if roleName == rbac.AdminRole && adminCount <= 1 {
+		utils.WriteJSONError(w, http.StatusConflict, "last_admin", "cannot revoke the admin role from the last admin")
+		return
+	}
+
+	err = tx.Commit(r.Context())
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to commit transaction")
+		return
+	}
+	utils.WriteJSONSuccess(w, http.StatusOK)
+}
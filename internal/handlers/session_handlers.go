@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"net/http"
+	"rest-api/internal/middlewares"
+	"rest-api/internal/models"
+	"rest-api/internal/rbac"
+	"rest-api/utils"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func (api *API) RegisterSessions(r chi.Router) {
+	r.Group(func(gr chi.Router) {
+		gr.Use(middlewares.AuthCheck(api.Pool, api.Config))
+		gr.Use(middlewares.RequireSession)
+		gr.Use(middlewares.NoImpersonation)
+		gr.Get("/auth/sessions", api.getMySessions)
+		gr.Delete("/auth/sessions/{id}", api.revokeMySession)
+		gr.Post("/auth/logout-all", api.logoutAllHandler)
+		gr.Get("/auth/csrf", api.csrfTokenHandler)
+
+		gr.Group(func(admin chi.Router) {
+			admin.Use(api.require(rbac.UsersManage))
+			admin.Get("/users/{id}/sessions", api.getUserSessions)
+			admin.Delete("/users/{id}/sessions/{sessionId}", api.revokeUserSession)
+			admin.Post("/users/{id}/logout-all", api.logoutUserEverywhere)
+		})
+	})
+}
+
+func (api *API) getMySessions(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middlewares.UserIDKey).(int64)
+	if !ok || userID == 0 {
+		utils.WriteJSONError(w, http.StatusUnauthorized, "not_authorized", "you are not authorized")
+		return
+	}
+	sessionID, _ := r.Context().Value(middlewares.SessionIDKey).(int64)
+
+	sessions, err := api.listSessions(r.Context(), userID, sessionID)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to fetch sessions")
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, sessions)
+}
+
+func (api *API) revokeMySession(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middlewares.UserIDKey).(int64)
+	if !ok || userID == 0 {
+		utils.WriteJSONError(w, http.StatusUnauthorized, "not_authorized", "you are not authorized")
+		return
+	}
+
+	sessionID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || sessionID <= 0 {
+		utils.WriteJSONError(w, http.StatusBadRequest, "invalid_session_id", "session id must be a positive integer")
+		return
+	}
+
+	found, err := api.revokeSession(r.Context(), userID, sessionID)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "session_delete_failed", "failed to delete session")
+		return
+	}
+	if !found {
+		utils.WriteJSONError(w, http.StatusNotFound, "not_found", "session with this id does not exist")
+		return
+	}
+
+	currentID, _ := r.Context().Value(middlewares.SessionIDKey).(int64)
+	if currentID == sessionID {
+		utils.ClearSessionCookie(w)
+		utils.ClearCSRFCookie(w, api.Config.CSRFCookieDomain)
+	}
+	utils.WriteJSONSuccess(w, http.StatusOK)
+}
+
+func (api *API) logoutAllHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middlewares.UserIDKey).(int64)
+	if !ok || userID == 0 {
+		utils.WriteJSONError(w, http.StatusUnauthorized, "not_authorized", "you are not authorized")
+		return
+	}
+
+	err := api.revokeAllSessions(r.Context(), userID)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "session_delete_failed", "failed to delete sessions")
+		return
+	}
+
+	utils.ClearSessionCookie(w)
+	utils.ClearCSRFCookie(w, api.Config.CSRFCookieDomain)
+	utils.WriteJSONSuccess(w, http.StatusOK)
+}
+
+func (api *API) csrfTokenHandler(w http.ResponseWriter, r *http.Request) {
+	sessionID, ok := r.Context().Value(middlewares.SessionIDKey).(int64)
+	if !ok || sessionID == 0 {
+		utils.WriteJSONError(w, http.StatusUnauthorized, "not_authorized", "you are not authorized")
+		return
+	}
+
+	token, err := utils.GenerateSessionToken()
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "token_generation_failed", "failed to create token")
+		return
+	}
+
+	var expiresAt time.Time
+	err = api.Pool.QueryRow(
+		r.Context(),
+		"update sessions set csrf_token_hash = $1 where id = $2 returning expires_at",
+		utils.HashTokenHMAC(token), sessionID,
+	).Scan(&expiresAt)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to save csrf token")
+		return
+	}
+
+	utils.SetCSRFCookie(w, token, expiresAt, api.Config.CSRFCookieDomain)
+	utils.WriteJSON(w, http.StatusOK, models.CSRFTokenResponse{CSRFToken: token})
+}
+
+func (api *API) getUserSessions(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || userID <= 0 {
+		utils.WriteJSONError(w, http.StatusBadRequest, "invalid_id", "user id must be a positive integer")
+		return
+	}
+
+	sessions, err := api.listSessions(r.Context(), userID, 0)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to fetch sessions")
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, sessions)
+}
+
+func (api *API) revokeUserSession(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || userID <= 0 {
+		utils.WriteJSONError(w, http.StatusBadRequest, "invalid_id", "user id must be a positive integer")
+		return
+	}
+
+	sessionID, err := strconv.ParseInt(chi.URLParam(r, "sessionId"), 10, 64)
+	if err != nil || sessionID <= 0 {
+		utils.WriteJSONError(w, http.StatusBadRequest, "invalid_session_id", "session id must be a positive integer")
+		return
+	}
+
+	found, err := api.revokeSession(r.Context(), userID, sessionID)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "session_delete_failed", "failed to delete session")
+		return
+	}
+	if !found {
+		utils.WriteJSONError(w, http.StatusNotFound, "not_found", "session with this id does not exist")
+		return
+	}
+	utils.WriteJSONSuccess(w, http.StatusOK)
+}
+
+func (api *API) logoutUserEverywhere(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || userID <= 0 {
+		utils.WriteJSONError(w, http.StatusBadRequest, "invalid_id", "user id must be a positive integer")
+		return
+	}
+
+	err = api.revokeAllSessions(r.Context(), userID)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "session_delete_failed", "failed to delete sessions")
+		return
+	}
+	utils.WriteJSONSuccess(w, http.StatusOK)
+}
@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"rest-api/internal/models"
+	"rest-api/utils"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+type dbtx interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// rowScanner is satisfied by both pgx.Row and pgx.Rows, so one scan helper
+// serves single lookups and listings.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+type sessionOptions struct {
+	ip               string
+	userAgent        string
+	withRefresh      bool
+	familyID         string
+	refreshExpiresAt time.Time
+	impersonatorID   *int64
+	ttl              time.Duration
+}
+
+func (api *API) createSession(ctx context.Context, db dbtx, userID int64, opts sessionOptions) (*models.Session, error) {
+	token, err := utils.GenerateSessionToken()
+	if err != nil {
+		return nil, err
+	}
+	csrfToken, err := utils.GenerateSessionToken()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	session := &models.Session{
+		UserId:     userID,
+		Token:      token,
+		CreatedAt:  now,
+		ExpiresAt:  now.Add(api.Config.SessionTTL),
+		LastUsedAt: now,
+		IP:         opts.ip,
+		UserAgent:  opts.userAgent,
+		CSRFToken:  csrfToken,
+	}
+	if opts.ttl > 0 {
+		session.ExpiresAt = now.Add(opts.ttl)
+	}
+
+	var (
+		refreshHash      *string
+		refreshExpiresAt *time.Time
+		familyID         *string
+	)
+	if opts.withRefresh {
+		session.RefreshToken, err = utils.GenerateSessionToken()
+		if err != nil {
+			return nil, err
+		}
+
+		session.FamilyId = opts.familyID
+		if session.FamilyId == "" {
+			session.FamilyId, err = utils.GenerateSessionToken()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		session.RefreshExpiresAt = opts.refreshExpiresAt
+		if session.RefreshExpiresAt.IsZero() {
+			session.RefreshExpiresAt = now.Add(api.Config.RefreshTokenTTL)
+		}
+		session.ExpiresAt = now.Add(api.Config.AccessTokenTTL)
+		if session.ExpiresAt.After(session.RefreshExpiresAt) {
+			session.ExpiresAt = session.RefreshExpiresAt
+		}
+
+		hash := utils.HashTokenHMAC(session.RefreshToken)
+		refreshHash = &hash
+		refreshExpiresAt = &session.RefreshExpiresAt
+		familyID = &session.FamilyId
+	}
+
+	err = db.QueryRow(
+		ctx,
+		`insert into sessions(user_id, token_hash, key_id, created_at, expires_at, last_used_at, refresh_token_hash, refresh_expires_at, family_id, ip, user_agent, csrf_token_hash, impersonator_id)
+		 values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13) returning id`,
+		userID, utils.HashTokenHMAC(token), utils.CurrentKeyID(), session.CreatedAt, session.ExpiresAt, session.LastUsedAt,
+		refreshHash, refreshExpiresAt, familyID, session.IP, session.UserAgent, utils.HashTokenHMAC(csrfToken), opts.impersonatorID,
+	).Scan(&session.Id)
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+func newSessionOptions(r *http.Request) sessionOptions {
+	return sessionOptions{
+		ip:        utils.ClientIP(r),
+		userAgent: r.UserAgent(),
+	}
+}
+
+// activeSessionCondition matches the sessions that can still be used. idle
+// is the placeholder holding the moment before which a session without a
+// refresh token counts as idle, see idleCutoff.
+func activeSessionCondition(idle string) string {
+	return `((refresh_expires_at is null and expires_at > now() and last_used_at > ` + idle + `)
+	or (refresh_expires_at > now() and refresh_used_at is null))`
+}
+
+// idleCutoff is the last_used_at a session needs to not have been idle for
+// longer than SessionIdleTimeout.
+func (api *API) idleCutoff() time.Time {
+	if api.Config.SessionIdleTimeout <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(-api.Config.SessionIdleTimeout)
+}
+
+func (api *API) listSessions(ctx context.Context, userID, currentSessionID int64) ([]models.SessionResponse, error) {
+	rows, err := api.Pool.Query(
+		ctx,
+		`select id, created_at, last_used_at, coalesce(refresh_expires_at, expires_at), coalesce(ip, ''), coalesce(user_agent, '')
+		 from sessions
+		 where user_id = $1 and `+activeSessionCondition("$2")+`
+		 order by last_used_at desc`,
+		userID, api.idleCutoff(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []models.SessionResponse{}
+	for rows.Next() {
+		var session models.SessionResponse
+		err := rows.Scan(
+			&session.Id,
+			&session.CreatedAt,
+			&session.LastUsedAt,
+			&session.ExpiresAt,
+			&session.IP,
+			&session.UserAgent,
+		)
+		if err != nil {
+			return nil, err
+		}
+		session.Current = session.Id == currentSessionID
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+func (api *API) revokeSession(ctx context.Context, userID, sessionID int64) (bool, error) {
+	tag, err := api.Pool.Exec(
+		ctx,
+		`delete from sessions
+		 where user_id = $1
+		   and (id = $2 or family_id = (select family_id from sessions where id = $2 and user_id = $1))`,
+		userID, sessionID,
+	)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func (api *API) revokeAllSessions(ctx context.Context, userID int64) error {
+	_, err := api.Pool.Exec(ctx, "delete from sessions where user_id = $1", userID)
+	return err
+}
@@ -0,0 +1,412 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"rest-api/config"
+	"rest-api/internal/middlewares"
+	"rest-api/internal/models"
+	"rest-api/internal/rbac"
+	"rest-api/utils"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// taskDependencyLock is the advisory lock key held while a dependency is
+// added, so two concurrent links cannot close a cycle together.
+const taskDependencyLock = 7_201_025
+
+var errOpenBlockers = errors.New("task has open blockers")
+
+// writeDependencyError answers the error of checkBlockersDone and reports
+// whether err was it.
+func writeDependencyError(w http.ResponseWriter, err error, blockers []int64) bool {
+	if !errors.Is(err, errOpenBlockers) {
+		return false
+	}
+	ids := make([]string, len(blockers))
+	for i, id := range blockers {
+		ids[i] = strconv.FormatInt(id, 10)
+	}
+	utils.WriteJSONError(w, http.StatusConflict, "open_blockers",
+		"task is blocked by open tasks: "+strings.Join(ids, ", "))
+	return true
+}
+
+// checkBlockersDone returns the open blockers of taskID. Under the block rule
+// it also fails with errOpenBlockers when there are any.
+func (api *API) checkBlockersDone(ctx context.Context, db dbtx, taskID int64) ([]int64, error) {
+	rows, err := db.Query(
+		ctx,
+		`select d.blocker_id from task_dependencies d
+		 join tasks t on t.id = d.blocker_id
+		 where d.blocked_id = $1 and not t.is_completed
+		 order by d.blocker_id`,
+		taskID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	blockers := []int64{}
+	for rows.Next() {
+		var id int64
+		err := rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		blockers = append(blockers, id)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	if len(blockers) > 0 && api.Config.DependencyCompletion == config.DependencyCompletionBlock {
+		return blockers, errOpenBlockers
+	}
+	return blockers, nil
+}
+
+// fetchGraphNodes loads the given tasks. Tasks the viewer cannot see keep
+// only their id and completion state.
+func (api *API) fetchGraphNodes(ctx context.Context, ids []int64, viewerID int64, readAll bool) (map[int64]models.TaskGraphNode, error) {
+	rows, err := api.Pool.Query(
+		ctx,
+		`select t.id, t.title, t.status, t.is_completed, t.due_at, `+taskVisibleTo("t.id", "$2")+`
+		 from tasks t where t.id = any($1)`,
+		ids, viewerID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	nodes := map[int64]models.TaskGraphNode{}
+	for rows.Next() {
+		var (
+			node     models.TaskGraphNode
+			assigned bool
+		)
+		err := rows.Scan(&node.Id, &node.Title, &node.Status, &node.IsCompleted, &node.DueAt, &assigned)
+		if err != nil {
+			return nil, err
+		}
+		if !assigned && !readAll {
+			node = models.TaskGraphNode{Id: node.Id, IsCompleted: node.IsCompleted, Hidden: true}
+		}
+		nodes[node.Id] = node
+	}
+	return nodes, rows.Err()
+}
+
+func (api *API) getTaskDependencies(w http.ResponseWriter, r *http.Request) {
+	taskID, ok := taskIDParam(w, r)
+	if !ok || !api.checkTaskAccess(w, r, taskID) {
+		return
+	}
+	userID, _ := r.Context().Value(middlewares.UserIDKey).(int64)
+
+	rows, err := api.Pool.Query(
+		r.Context(),
+		`select blocker_id, blocked_id from task_dependencies
+		 where blocked_id = $1 or blocker_id = $1
+		 order by blocker_id, blocked_id`,
+		taskID,
+	)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to fetch dependencies")
+		return
+	}
+	defer rows.Close()
+
+	var edges []models.TaskGraphEdge
+	ids := []int64{}
+	for rows.Next() {
+		var edge models.TaskGraphEdge
+		err := rows.Scan(&edge.From, &edge.To)
+		if err != nil {
+			utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to scan dependency row")
+			return
+		}
+		edges = append(edges, edge)
+		ids = append(ids, edge.From, edge.To)
+	}
+	if rows.Err() != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to fetch dependencies")
+		return
+	}
+
+	nodes, err := api.fetchGraphNodes(r.Context(), ids, userID, middlewares.HasPermission(r.Context(), rbac.TasksReadAll))
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to fetch dependencies")
+		return
+	}
+
+	response := models.TaskDependenciesResponse{
+		BlockedBy: []models.TaskGraphNode{},
+		Blocks:    []models.TaskGraphNode{},
+	}
+	for _, edge := range edges {
+		if edge.To == taskID {
+			response.BlockedBy = append(response.BlockedBy, nodes[edge.From])
+		} else {
+			response.Blocks = append(response.Blocks, nodes[edge.To])
+		}
+	}
+
+	utils.WriteJSON(w, http.StatusOK, response)
+}
+
+// addTaskDependency records that the blocker has to be completed before the
+// task in the path. Links that would close a cycle are refused.
+func (api *API) addTaskDependency(w http.ResponseWriter, r *http.Request) {
+	taskID, ok := taskIDParam(w, r)
+	if !ok || !api.checkTaskAccess(w, r, taskID) {
+		return
+	}
+	userID, _ := r.Context().Value(middlewares.UserIDKey).(int64)
+
+	var req models.TaskDependencyRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if req.BlockerId <= 0 {
+		utils.WriteJSONValidationError(w, "blocker_id", "blocker_id must be a positive integer")
+		return
+	}
+
+	tx, err := api.Pool.Begin(r.Context())
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to start transaction")
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	_, err = tx.Exec(r.Context(), "select pg_advisory_xact_lock($1)", taskDependencyLock)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to add dependency")
+		return
+	}
+
+	var exists, assigned bool
+	err = tx.QueryRow(
+		r.Context(),
+		`select exists(select 1 from tasks where id = $1), `+taskVisibleTo("$1", "$2"),
+		req.BlockerId, userID,
+	).Scan(&exists, &assigned)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to add dependency")
+		return
+	}
+	if !exists || (!assigned && !middlewares.HasPermission(r.Context(), rbac.TasksReadAll)) {
+		utils.WriteJSONValidationError(w, "blocker_id", "task with this id does not exist")
+		return
+	}
+
+	// The new link closes a cycle if the blocker already waits, directly or
+	// not, for this task.
+	var cycle bool
+	err = tx.QueryRow(
+		r.Context(),
+		`with recursive downstream(id) as (
+		     select $1::bigint
+		     union
+		     select d.blocked_id from task_dependencies d join downstream on d.blocker_id = downstream.id
+		 )
+		 select exists(select 1 from downstream where id = $2)`,
+		taskID, req.BlockerId,
+	).Scan(&cycle)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to add dependency")
+		return
+	}
+	if cycle {
+		utils.WriteJSONError(w, http.StatusConflict, "dependency_cycle", "this dependency would create a cycle")
+		return
+	}
+
+	_, err = tx.Exec(
+		r.Context(),
+		`insert into task_dependencies(blocker_id, blocked_id, created_by) values ($1, $2, $3)
+		 on conflict do nothing`,
+		req.BlockerId, taskID, userID,
+	)
+	if err == nil {
+		err = tx.Commit(r.Context())
+	}
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to add dependency")
+		return
+	}
+
+	utils.WriteJSONSuccess(w, http.StatusCreated)
+}
+
+func (api *API) removeTaskDependency(w http.ResponseWriter, r *http.Request) {
+	taskID, ok := taskIDParam(w, r)
+	if !ok || !api.checkTaskAccess(w, r, taskID) {
+		return
+	}
+	blockerID, err := strconv.ParseInt(chi.URLParam(r, "blockerId"), 10, 64)
+	if err != nil || blockerID <= 0 {
+		utils.WriteJSONError(w, http.StatusBadRequest, "invalid_task_id", "blocker id must be a positive integer")
+		return
+	}
+
+	tag, err := api.Pool.Exec(
+		r.Context(),
+		"delete from task_dependencies where blocker_id = $1 and blocked_id = $2",
+		blockerID, taskID,
+	)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to remove dependency")
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		utils.WriteJSONError(w, http.StatusNotFound, "not_found", "dependency does not exist")
+		return
+	}
+	utils.WriteJSONSuccess(w, http.StatusOK)
+}
+
+// getTaskGraph returns every task the given one transitively waits for or
+// holds up, with the links between them and the critical path: the longest
+// chain of open tasks.
+func (api *API) getTaskGraph(w http.ResponseWriter, r *http.Request) {
+	taskID, ok := taskIDParam(w, r)
+	if !ok || !api.checkTaskAccess(w, r, taskID) {
+		return
+	}
+	userID, _ := r.Context().Value(middlewares.UserIDKey).(int64)
+
+	rows, err := api.Pool.Query(
+		r.Context(),
+		`with recursive
+		     upstream(id) as (
+		         select $1::bigint
+		         union
+		         select d.blocker_id from task_dependencies d join upstream on d.blocked_id = upstream.id
+		     ),
+		     downstream(id) as (
+		         select $1::bigint
+		         union
+		         select d.blocked_id from task_dependencies d join downstream on d.blocker_id = downstream.id
+		     ),
+		     reachable(id) as (
+		         select id from upstream union select id from downstream
+		     )
+		 select d.blocker_id, d.blocked_id from task_dependencies d
+		 where d.blocker_id in (select id from reachable) and d.blocked_id in (select id from reachable)
+		 order by d.blocker_id, d.blocked_id`,
+		taskID,
+	)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to fetch dependency graph")
+		return
+	}
+	defer rows.Close()
+
+	graph := models.TaskGraph{Nodes: []models.TaskGraphNode{}, Edges: []models.TaskGraphEdge{}, CriticalPath: []int64{}}
+	ids := []int64{taskID}
+	seen := map[int64]bool{taskID: true}
+	for rows.Next() {
+		var edge models.TaskGraphEdge
+		err := rows.Scan(&edge.From, &edge.To)
+		if err != nil {
+			utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to scan dependency row")
+			return
+		}
+		graph.Edges = append(graph.Edges, edge)
+		for _, id := range []int64{edge.From, edge.To} {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	if rows.Err() != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to fetch dependency graph")
+		return
+	}
+
+	nodes, err := api.fetchGraphNodes(r.Context(), ids, userID, middlewares.HasPermission(r.Context(), rbac.TasksReadAll))
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to fetch dependency graph")
+		return
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		graph.Nodes = append(graph.Nodes, nodes[id])
+	}
+	graph.CriticalPath = criticalPath(graph.Nodes, graph.Edges)
+
+	utils.WriteJSON(w, http.StatusOK, graph)
+}
+
+// criticalPath finds the chain with the most open tasks in the dependency
+// DAG and returns its open tasks in order. Ties go to the lowest ids.
+func criticalPath(nodes []models.TaskGraphNode, edges []models.TaskGraphEdge) []int64 {
+	open := map[int64]bool{}
+	indegree := map[int64]int{}
+	next := map[int64][]int64{}
+	for _, node := range nodes {
+		open[node.Id] = !node.IsCompleted
+		indegree[node.Id] = 0
+	}
+	for _, edge := range edges {
+		next[edge.From] = append(next[edge.From], edge.To)
+		indegree[edge.To]++
+	}
+
+	ready := []int64{}
+	for _, node := range nodes {
+		if indegree[node.Id] == 0 {
+			ready = append(ready, node.Id)
+		}
+	}
+
+	length := map[int64]int{}
+	prev := map[int64]int64{}
+	var best int64
+	for len(ready) > 0 {
+		sort.Slice(ready, func(i, j int) bool { return ready[i] < ready[j] })
+		id := ready[0]
+		ready = ready[1:]
+
+		if open[id] {
+			length[id]++
+		}
+		if length[id] > length[best] || (length[id] == length[best] && id < best) || best == 0 {
+			best = id
+		}
+		for _, to := range next[id] {
+			if _, ok := prev[to]; !ok || length[id] > length[prev[to]] {
+				prev[to] = id
+				length[to] = length[id]
+			}
+			indegree[to]--
+			if indegree[to] == 0 {
+				ready = append(ready, to)
+			}
+		}
+	}
+
+	path := []int64{}
+	if length[best] == 0 {
+		return path
+	}
+	for id, ok := best, true; ok; id, ok = prev[id] {
+		if open[id] {
+			path = append(path, id)
+		}
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path
+}
@@ -1,11 +1,14 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
 	"rest-api/internal/middlewares"
 	"rest-api/internal/models"
+	"rest-api/internal/rbac"
 	"rest-api/utils"
 	"strconv"
 
@@ -15,50 +18,68 @@ import (
 
 func (api *API) RegisterTasks(r chi.Router) {
 	r.Group(func(gr chi.Router) {
-		gr.Use(middlewares.AuthCheck(api.Pool))
-		gr.Use(middlewares.AddUserStatus(api.Pool))
-
-		gr.Get("/tasks", api.getTasks)
-		gr.Post("/tasks/{id}/complete", api.completeTaskHandler)
-
-		gr.Group(func(admin chi.Router) {
-			admin.Use(middlewares.UserStatusCheck(api.Pool))
-			admin.Post("/tasks", api.createTaskHandler)
-			admin.Post("/tasks/{id}/users", api.bindUserHandler)
-		})
+		gr.Use(middlewares.AuthCheck(api.Pool, api.Config))
+		gr.Use(middlewares.AddUserStatus(api.Pool, api.Config))
+
+		gr.With(api.require(rbac.TasksRead)).Get("/tasks", api.getTasks)
+		gr.With(api.require(rbac.TasksRead)).Get("/tasks/overdue", api.getOverdueTasks)
+		gr.With(api.require(rbac.TasksRead)).Get("/tasks/workflow", api.getTaskWorkflow)
+		gr.With(api.require(rbac.WorkflowManage)).Put("/tasks/workflow", api.replaceTaskWorkflow)
+		gr.With(api.require(rbac.TasksRead)).Get("/tasks/{id}", api.getTask)
+		gr.With(api.require(rbac.TasksRead)).Get("/tasks/{id}/subtasks", api.getSubtasks)
+		gr.With(api.require(rbac.TasksRead)).Get("/tasks/{id}/tree", api.getTaskTree)
+		gr.With(api.require(rbac.TasksRead)).Get("/tasks/{id}/dependencies", api.getTaskDependencies)
+		gr.With(api.require(rbac.TasksUpdate)).Post("/tasks/{id}/dependencies", api.addTaskDependency)
+		gr.With(api.require(rbac.TasksUpdate)).Delete("/tasks/{id}/dependencies/{blockerId}", api.removeTaskDependency)
+		gr.With(api.require(rbac.TasksRead)).Get("/tasks/{id}/graph", api.getTaskGraph)
+		gr.With(api.require(rbac.TasksUpdate)).Patch("/tasks/{id}", api.updateTaskHandler)
+		gr.With(api.require(rbac.TasksDelete)).Delete("/tasks/{id}", api.deleteTaskHandler)
+		gr.With(api.require(rbac.TasksComplete)).Post("/tasks/{id}/complete", api.completeTaskHandler)
+		gr.With(api.require(rbac.TasksRead)).Post("/tasks/{id}/transition", api.transitionTaskHandler)
+		gr.With(api.require(rbac.TasksCreate)).Post("/tasks", api.createTaskHandler)
+		gr.With(api.require(rbac.TasksRead)).Get("/tasks/{id}/users", api.getTaskUsers)
+		gr.With(api.require(rbac.TasksAssign)).Post("/tasks/{id}/users", api.bindUserHandler)
+		gr.With(api.require(rbac.TasksAssign)).Put("/tasks/{id}/users", api.replaceTaskUsersHandler)
+		gr.With(api.require(rbac.TasksAssign)).Delete("/tasks/{id}/users", api.unbindUserHandler)
 	})
 }
 
 func (api *API) getTasks(w http.ResponseWriter, r *http.Request) {
+	query, err := parseTaskListQuery(r.URL.Query())
+	if err != nil {
+		writeValidationError(w, err)
+		return
+	}
+	api.listTasks(w, r, query)
+}
+
+// getOverdueTasks lists open tasks past their due date, most overdue first.
+// Users see their own; with tasks.read_all everyone's, narrowed by assignee.
+func (api *API) getOverdueTasks(w http.ResponseWriter, r *http.Request) {
+	query := &taskListQuery{sort: "due_at", overdue: true}
+	err := query.parse(r.URL.Query())
+	if err != nil {
+		writeValidationError(w, err)
+		return
+	}
+	api.listTasks(w, r, query)
+}
+
+func (api *API) listTasks(w http.ResponseWriter, r *http.Request, query *taskListQuery) {
 	userID, ok := r.Context().Value(middlewares.UserIDKey).(int64)
 	if !ok || userID == 0 {
 		utils.WriteJSONError(w, http.StatusUnauthorized, "not_authorized", "you are not authorized")
 		return
 	}
 
-	isAdmin, _ := r.Context().Value(middlewares.IsAdminKey).(bool)
-
-	var (
-		rows pgx.Rows
-		err  error
-	)
-	if isAdmin {
-
-		rows, err = api.Pool.Query(
-			r.Context(),
-			"select id, title, description, created_at, is_completed from tasks",
-		)
-	} else {
-		rows, err = api.Pool.Query(
-			r.Context(),
-			`select t.id, t.title, t.description, t.created_at, t.is_completed
-			 from tasks t
-			 join task_users tu on tu.task_id = t.id
-			 where tu.user_id = $1`,
-			userID,
-		)
+	onlyAssigned := !middlewares.HasPermission(r.Context(), rbac.TasksReadAll)
+	sql, args, err := query.sql(userID, onlyAssigned)
+	if err != nil {
+		writeValidationError(w, err)
+		return
 	}
 
+	rows, err := api.Pool.Query(r.Context(), sql, args...)
 	if err != nil {
 		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to fetch tasks")
 		return
@@ -68,21 +89,27 @@ func (api *API) getTasks(w http.ResponseWriter, r *http.Request) {
 	tasks := []models.Task{}
 	for rows.Next() {
 		var task models.Task
-		err := rows.Scan(
-			&task.Id,
-			&task.Title,
-			&task.Description,
-			&task.CreatedAt,
-			&task.IsCompleted,
-		)
+		err := scanTask(rows, &task)
 		if err != nil {
 			utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to scan task row")
 			return
 		}
 		tasks = append(tasks, task)
 	}
+	if rows.Err() != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to fetch tasks")
+		return
+	}
 
-	utils.WriteJSON(w, http.StatusOK, tasks)
+	response := models.TaskListResponse{Items: tasks}
+	if len(tasks) > query.limit {
+		response.Items = tasks[:query.limit]
+		last := response.Items[query.limit-1]
+		next := query.cursorAfter(last)
+		response.NextCursor = &next
+	}
+
+	utils.WriteJSON(w, http.StatusOK, response)
 }
 
 func (api *API) createTaskHandler(w http.ResponseWriter, r *http.Request) {
@@ -111,10 +138,39 @@ func (api *API) createTaskHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if task.Priority == "" {
+		task.Priority = utils.DefaultTaskPriority
+	}
+	if task.CompletionPolicy == "" {
+		task.CompletionPolicy = utils.DefaultCompletionPolicy
+	}
+	err = utils.ValidateTaskPlanning(task.Priority, task.StartAt, task.DueAt)
+	if err == nil {
+		err = utils.ValidateCompletionPolicy(task.CompletionPolicy, task.OwnerId)
+	}
+	if err == nil {
+		err = api.checkTaskOwner(r.Context(), api.Pool, task.OwnerId)
+	}
+	if err == nil {
+		err = api.checkTaskParent(r.Context(), api.Pool, 0, task.ParentId)
+	}
+	if err == nil && !task.Is_completed {
+		err = api.checkParentOpen(r.Context(), api.Pool, task.ParentId)
+	}
+	if writeHierarchyError(w, err) {
+		return
+	}
+	if err != nil {
+		writeValidationError(w, err)
+		return
+	}
+
 	_, err = api.Pool.Exec(
 		r.Context(),
-		"insert into tasks(title, description, is_completed) values ($1, $2, $3)",
-		task.Title, task.Description, task.Is_completed,
+		`insert into tasks(title, description, is_completed, priority, start_at, due_at, status, completion_policy, owner_id, parent_id)
+		 values ($1, $2, $3, $4, $5, $6, case when $3 then `+completedStatus+` else `+initialStatus+` end, $7, $8, $9)`,
+		task.Title, task.Description, task.Is_completed, task.Priority, task.StartAt, task.DueAt,
+		task.CompletionPolicy, task.OwnerId, task.ParentId,
 	)
 	if err != nil {
 		utils.WriteJSONError(w, http.StatusInternalServerError, "task_creation_failed", "failed to create task")
@@ -126,143 +182,544 @@ func (api *API) createTaskHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (api *API) bindUserHandler(w http.ResponseWriter, r *http.Request) {
-	idStr := chi.URLParam(r, "id")
-	taskId, err := strconv.Atoi(idStr)
-	if err != nil || taskId <= 0 {
-		utils.WriteJSONError(w, http.StatusBadRequest, "invalid_task_id", "task id must be a positive integer")
+	api.changeTaskUsers(w, r, false, func(ctx context.Context, tx pgx.Tx, taskID int64, userIDs []int64) error {
+		_, err := tx.Exec(
+			ctx,
+			`insert into task_users(task_id, user_id)
+			 select $1, u.id from unnest($2::bigint[]) as u(id)
+			 where not exists(select 1 from task_users where task_id = $1 and user_id = u.id)`,
+			taskID, userIDs,
+		)
+		return err
+	})
+}
+
+func (api *API) unbindUserHandler(w http.ResponseWriter, r *http.Request) {
+	api.changeTaskUsers(w, r, false, func(ctx context.Context, tx pgx.Tx, taskID int64, userIDs []int64) error {
+		_, err := tx.Exec(ctx, "delete from task_users where task_id = $1 and user_id = any($2)", taskID, userIDs)
+		return err
+	})
+}
+
+// replaceTaskUsersHandler makes the assignee list exactly match the request,
+// for syncing assignments from another system. An empty list unassigns all.
+func (api *API) replaceTaskUsersHandler(w http.ResponseWriter, r *http.Request) {
+	api.changeTaskUsers(w, r, true, func(ctx context.Context, tx pgx.Tx, taskID int64, userIDs []int64) error {
+		_, err := tx.Exec(ctx, "delete from task_users where task_id = $1 and not (user_id = any($2))", taskID, userIDs)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(
+			ctx,
+			`insert into task_users(task_id, user_id)
+			 select $1, u.id from unnest($2::bigint[]) as u(id)
+			 where not exists(select 1 from task_users where task_id = $1 and user_id = u.id)`,
+			taskID, userIDs,
+		)
+		return err
+	})
+}
+
+// changeTaskUsers validates a user_ids request and runs apply in a single
+// transaction with the task row locked, so concurrent changes to the same
+// task's assignees are serialized.
+func (api *API) changeTaskUsers(w http.ResponseWriter, r *http.Request, allowEmpty bool, apply func(ctx context.Context, tx pgx.Tx, taskID int64, userIDs []int64) error) {
+	taskID, ok := taskIDParam(w, r)
+	if !ok {
 		return
 	}
 
-	var req struct {
-		UserIds []int `json:"user_ids"`
+	var req models.TaskUsersRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if req.UserIds == nil || (len(req.UserIds) == 0 && !allowEmpty) {
+		utils.WriteJSONError(w, http.StatusBadRequest, "validation_error", "user_ids array cannot be empty")
+		return
 	}
 
-	body, err := io.ReadAll(r.Body)
+	seen := map[int64]bool{}
+	userIDs := []int64{}
+	for _, userID := range req.UserIds {
+		if userID <= 0 {
+			utils.WriteJSONError(w, http.StatusBadRequest, "invalid_user_id", "user id must be a positive integer")
+			return
+		}
+		if !seen[userID] {
+			seen[userID] = true
+			userIDs = append(userIDs, userID)
+		}
+	}
+
+	tx, err := api.Pool.Begin(r.Context())
 	if err != nil {
-		utils.WriteJSONError(w, http.StatusBadRequest, "invalid_request", "failed to read body")
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to start transaction")
 		return
 	}
+	defer tx.Rollback(r.Context())
 
-	err = json.Unmarshal(body, &req)
+	var lockedID int64
+	err = tx.QueryRow(r.Context(), "select id from tasks where id = $1 for update", taskID).Scan(&lockedID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		utils.WriteJSONError(w, http.StatusNotFound, "not_found", "task with this id does not exist")
+		return
+	}
 	if err != nil {
-		utils.WriteJSONError(w, http.StatusBadRequest, "invalid_json", "request body is not valid JSON")
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to check task existence")
 		return
 	}
 
-	if len(req.UserIds) == 0 {
-		utils.WriteJSONError(w, http.StatusBadRequest, "validation_error", "user_ids array cannot be empty")
+	var existing int
+	err = tx.QueryRow(r.Context(), "select count(*) from users where id = any($1)", userIDs).Scan(&existing)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to check user existence")
+		return
+	}
+	if existing != len(userIDs) {
+		utils.WriteJSONError(w, http.StatusNotFound, "not_found", "user with id does not exist")
 		return
 	}
 
-	var taskExists bool
-	err = api.Pool.QueryRow(
-		r.Context(),
-		"select exists(select 1 from tasks where id = $1)",
-		taskId,
-	).Scan(&taskExists)
+	err = apply(r.Context(), tx, taskID, userIDs)
+	if err == nil {
+		err = tx.Commit(r.Context())
+	}
 	if err != nil {
-		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to check task existence")
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to update task assignees")
 		return
 	}
-	if !taskExists {
-		utils.WriteJSONError(w, http.StatusNotFound, "not_found", "task with this id does not exist")
+
+	utils.WriteJSONSuccess(w, http.StatusOK)
+}
+
+func (api *API) getTaskUsers(w http.ResponseWriter, r *http.Request) {
+	taskID, ok := taskIDParam(w, r)
+	if !ok || !api.checkTaskAccess(w, r, taskID) {
 		return
 	}
 
-	for _, userId := range req.UserIds {
-		if userId <= 0 {
-			utils.WriteJSONError(w, http.StatusBadRequest, "invalid_user_id", "user id must be a positive integer")
-			return
-		}
+	rows, err := api.Pool.Query(
+		r.Context(),
+		`select u.id, u.family, u.name, u.surname
+		 from task_users tu
+		 join users u on u.id = tu.user_id
+		 where tu.task_id = $1
+		 order by u.id`,
+		taskID,
+	)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to fetch task users")
+		return
+	}
+	defer rows.Close()
 
-		var userExists bool
-		err = api.Pool.QueryRow(
-			r.Context(),
-			"select exists(select 1 from users where id = $1)",
-			userId,
-		).Scan(&userExists)
+	users := []models.UserPublicResponse{}
+	for rows.Next() {
+		user := models.UserPublicResponse{}
+		err := rows.Scan(
+			&user.Id,
+			&user.Family,
+			&user.Name,
+			&user.Surname,
+		)
 		if err != nil {
-			utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to check user existence")
+			utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to scan user row")
 			return
 		}
-		if !userExists {
-			utils.WriteJSONError(w, http.StatusNotFound, "not_found", "user with id does not exist")
+		users = append(users, user)
+	}
+
+	utils.WriteJSON(w, http.StatusOK, users)
+}
+
+// completeTaskHandler records the caller's own completion and completes the
+// task once its completion policy is met: any assignee, all assignees, or
+// the designated owner. The owner and callers with tasks.read_all who are
+// not assigned complete the task outright.
+func (api *API) completeTaskHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middlewares.UserIDKey).(int64)
+	if !ok || userID == 0 {
+		utils.WriteJSONError(w, http.StatusUnauthorized, "not_authorized", "you are not authorized")
+		return
+	}
+
+	taskID, ok := taskIDParam(w, r)
+	if !ok || !api.checkTaskAccess(w, r, taskID) {
+		return
+	}
+
+	tx, err := api.Pool.Begin(r.Context())
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to start transaction")
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	var (
+		status       string
+		wasCompleted bool
+	)
+	err = tx.QueryRow(
+		r.Context(),
+		"select status, is_completed from tasks where id = $1 for update",
+		taskID,
+	).Scan(&status, &wasCompleted)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to complete task")
+		return
+	}
+
+	done, err := api.recordCompletion(r.Context(), tx, taskID, userID)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to complete task")
+		return
+	}
+	done = done && !wasCompleted
+
+	var (
+		openBlockers []int64
+		to           string
+	)
+	if done {
+		to, err = api.legacyTransition(r.Context(), tx, status, true)
+		if writeTransitionError(w, err) {
 			return
 		}
-
-		var alreadyBound bool
-		err = api.Pool.QueryRow(
-			r.Context(),
-			"select exists(select 1 from task_users where task_id = $1 and user_id = $2)",
-			taskId, userId,
-		).Scan(&alreadyBound)
-		if err != nil {
-			utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to check existing binding")
+	}
+	if done && err == nil {
+		err = api.checkSubtasksDone(r.Context(), tx, taskID)
+		if writeHierarchyError(w, err) {
 			return
 		}
-		if alreadyBound {
-			continue
+	}
+	if done && err == nil {
+		openBlockers, err = api.checkBlockersDone(r.Context(), tx, taskID)
+		if writeDependencyError(w, err, openBlockers) {
+			return
 		}
-
-		_, err = api.Pool.Exec(
+	}
+	if done && err == nil {
+		_, err = tx.Exec(
 			r.Context(),
-			"insert into task_users(task_id, user_id) values ($1, $2)",
-			taskId, userId,
+			"update tasks set is_completed = true, status = $2 where id = $1",
+			taskID, to,
 		)
-		if err != nil {
-			utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to bind user")
-			return
-		}
+	}
+	if err == nil {
+		err = tx.Commit(r.Context())
+	}
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to complete task")
+		return
 	}
 
-	utils.WriteJSONSuccess(w, http.StatusOK)
+	utils.WriteJSON(w, http.StatusOK, models.TaskCompletionResponse{Status: "ok", OpenBlockers: openBlockers})
 }
 
-func (api *API) completeTaskHandler(w http.ResponseWriter, r *http.Request) {
+// recordCompletion stamps userID's own completion of a task the caller has
+// locked and reports whether its completion policy is now met. Callers who
+// are not assigned, like the owner or a manager, complete it outright.
+func (api *API) recordCompletion(ctx context.Context, db dbtx, taskID, userID int64) (bool, error) {
+	var (
+		policy  string
+		ownerID *int64
+	)
+	err := db.QueryRow(ctx, "select completion_policy, owner_id from tasks where id = $1", taskID).Scan(&policy, &ownerID)
+	if err != nil {
+		return false, err
+	}
+
+	tag, err := db.Exec(
+		ctx,
+		"update task_users set completed_at = coalesce(completed_at, now()) where task_id = $1 and user_id = $2",
+		taskID, userID,
+	)
+	if err != nil {
+		return false, err
+	}
+	assigned := tag.RowsAffected() > 0
+
+	var total, completed int
+	err = db.QueryRow(
+		ctx,
+		"select count(*), count(completed_at) from task_users where task_id = $1",
+		taskID,
+	).Scan(&total, &completed)
+	if err != nil {
+		return false, err
+	}
+
+	done := !assigned
+	switch policy {
+	case "any":
+		done = done || completed > 0
+	case "all":
+		done = done || completed == total
+	case "owner":
+		// A task whose owner was deleted falls back to any assignee.
+		done = done || (ownerID != nil && *ownerID == userID) || (ownerID == nil && completed > 0)
+	}
+	return done, nil
+}
+
+func (api *API) checkTaskOwner(ctx context.Context, db dbtx, ownerID *int64) error {
+	if ownerID == nil {
+		return nil
+	}
+	var exists bool
+	err := db.QueryRow(ctx, "select exists(select 1 from users where id = $1)", *ownerID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return &utils.ValidationError{Field: "owner_id", Message: "user with this id does not exist"}
+	}
+	return nil
+}
+
+// checkTaskAccess writes an error response and returns false when the task
+// does not exist or the caller may not see it. Without tasks.read_all only
+// the tasks a user is assigned to or owns are visible.
+func (api *API) checkTaskAccess(w http.ResponseWriter, r *http.Request, taskID int64) bool {
 	userID, ok := r.Context().Value(middlewares.UserIDKey).(int64)
 	if !ok || userID == 0 {
 		utils.WriteJSONError(w, http.StatusUnauthorized, "not_authorized", "you are not authorized")
-		return
+		return false
+	}
+
+	var taskExists, assigned bool
+	err := api.Pool.QueryRow(
+		r.Context(),
+		`select exists(select 1 from tasks where id = $1), `+taskVisibleTo("$1", "$2"),
+		taskID, userID,
+	).Scan(&taskExists, &assigned)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to check task access")
+		return false
 	}
 
-	idStr := chi.URLParam(r, "id")
-	taskID, err := strconv.Atoi(idStr)
+	if !taskExists {
+		utils.WriteJSONError(w, http.StatusNotFound, "not_found", "task with this id does not exist")
+		return false
+	}
+	if !assigned && !middlewares.HasPermission(r.Context(), rbac.TasksReadAll) {
+		utils.WriteJSONError(w, http.StatusForbidden, "forbidden", "you do not have access to this task")
+		return false
+	}
+	return true
+}
+
+func taskIDParam(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	taskID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
 	if err != nil || taskID <= 0 {
 		utils.WriteJSONError(w, http.StatusBadRequest, "invalid_task_id", "task id must be a positive integer")
+		return 0, false
+	}
+	return taskID, true
+}
+
+func (api *API) fetchTask(ctx context.Context, db dbtx, taskID, viewerID int64) (models.Task, error) {
+	var task models.Task
+	err := scanTask(db.QueryRow(ctx, "select "+taskColumns("$1")+" from tasks t where t.id = $2", viewerID, taskID), &task)
+	return task, err
+}
+
+func (api *API) getTask(w http.ResponseWriter, r *http.Request) {
+	taskID, ok := taskIDParam(w, r)
+	if !ok || !api.checkTaskAccess(w, r, taskID) {
+		return
+	}
+
+	userID, _ := r.Context().Value(middlewares.UserIDKey).(int64)
+	task, err := api.fetchTask(r.Context(), api.Pool, taskID, userID)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to fetch task")
 		return
 	}
+	utils.WriteJSON(w, http.StatusOK, task)
+}
 
-	isAdmin, _ := r.Context().Value(middlewares.IsAdminKey).(bool)
+func (api *API) updateTaskHandler(w http.ResponseWriter, r *http.Request) {
+	taskID, ok := taskIDParam(w, r)
+	if !ok || !api.checkTaskAccess(w, r, taskID) {
+		return
+	}
 
-	if !isAdmin {
+	var req models.TaskPatchRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
 
-		var hasAccess bool
-		err = api.Pool.QueryRow(
-			r.Context(),
-			"select exists(select 1 from task_users where task_id = $1 and user_id = $2)",
-			taskID,
-			userID,
-		).Scan(&hasAccess)
-		if err != nil {
-			utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to check task access")
-			return
-		}
+	tx, err := api.Pool.Begin(r.Context())
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to start transaction")
+		return
+	}
+	defer tx.Rollback(r.Context())
 
-		if !hasAccess {
-			utils.WriteJSONError(w, http.StatusForbidden, "forbidden", "you do not have access to this task")
-			return
+	_, err = tx.Exec(r.Context(), "select id from tasks where id = $1 for update", taskID)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to fetch task")
+		return
+	}
+
+	userID, _ := r.Context().Value(middlewares.UserIDKey).(int64)
+	task, err := api.fetchTask(r.Context(), tx, taskID, userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		utils.WriteJSONError(w, http.StatusNotFound, "not_found", "task with this id does not exist")
+		return
+	}
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to fetch task")
+		return
+	}
+
+	wasCompleted, oldPolicy, oldOwner := task.IsCompleted, task.CompletionPolicy, task.OwnerId
+	if req.Title != nil {
+		task.Title = *req.Title
+	}
+	if req.Description != nil {
+		task.Description = *req.Description
+	}
+	if req.IsCompleted != nil {
+		task.IsCompleted = *req.IsCompleted
+	}
+	if req.Priority != nil {
+		task.Priority = *req.Priority
+	}
+	if req.StartAt.Set {
+		task.StartAt = req.StartAt.Value
+	}
+	if req.DueAt.Set {
+		task.DueAt = req.DueAt.Value
+	}
+	if req.CompletionPolicy != nil {
+		task.CompletionPolicy = *req.CompletionPolicy
+	}
+	if req.OwnerId.Set {
+		task.OwnerId = req.OwnerId.Value
+	}
+	if req.ParentId.Set {
+		task.ParentId = req.ParentId.Value
+	}
+
+	// Only the owner and managers decide how a task gets completed, or an
+	// assignee could rewrite the policy they are bound by.
+	ownerChanged := (task.OwnerId == nil) != (oldOwner == nil) || (task.OwnerId != nil && oldOwner != nil && *task.OwnerId != *oldOwner)
+	if (ownerChanged || task.CompletionPolicy != oldPolicy) &&
+		(oldOwner == nil || *oldOwner != userID) && !middlewares.HasPermission(r.Context(), rbac.TasksReadAll) {
+		utils.WriteJSONError(w, http.StatusForbidden, "forbidden", "only the task owner or a user with tasks.read_all can change owner_id and completion_policy")
+		return
+	}
+
+	err = utils.ValidateTaskRequest(task.Title, task.Description)
+	if err == nil {
+		err = utils.ValidateTaskPlanning(task.Priority, task.StartAt, task.DueAt)
+	}
+	if err == nil {
+		err = utils.ValidateCompletionPolicy(task.CompletionPolicy, task.OwnerId)
+	}
+	if err == nil {
+		err = api.checkTaskOwner(r.Context(), tx, task.OwnerId)
+	}
+	if err == nil && req.ParentId.Set {
+		err = api.checkTaskParent(r.Context(), tx, taskID, task.ParentId)
+	}
+	// Like POST /tasks/{id}/complete, an assignee's is_completed records
+	// their own part and completes the task only once the policy is met.
+	if err == nil && !wasCompleted && task.IsCompleted {
+		task.IsCompleted, err = api.recordCompletion(r.Context(), tx, taskID, userID)
+	}
+	if err == nil && !task.IsCompleted && (req.ParentId.Set || wasCompleted) {
+		err = api.checkParentOpen(r.Context(), tx, task.ParentId)
+	}
+	if err == nil && task.IsCompleted != wasCompleted {
+		task.Status, err = api.legacyTransition(r.Context(), tx, task.Status, task.IsCompleted)
+	}
+	var openBlockers []int64
+	if err == nil && !wasCompleted && task.IsCompleted {
+		err = api.checkSubtasksDone(r.Context(), tx, taskID)
+		if err == nil {
+			openBlockers, err = api.checkBlockersDone(r.Context(), tx, taskID)
 		}
 	}
+	if writeTransitionError(w, err) || writeHierarchyError(w, err) || writeDependencyError(w, err, openBlockers) {
+		return
+	}
+	if err != nil {
+		writeValidationError(w, err)
+		return
+	}
 
-	_, err = api.Pool.Exec(
+	_, err = tx.Exec(
 		r.Context(),
-		"update tasks set is_completed = true where id = $1",
-		taskID,
+		`update tasks
+		 set title = $1, description = $2, priority = $4, start_at = $5, due_at = $6, is_completed = $3,
+		     status = $11, completion_policy = $7, owner_id = $8, parent_id = $9
+		 where id = $10`,
+		task.Title, task.Description, task.IsCompleted, task.Priority, task.StartAt, task.DueAt,
+		task.CompletionPolicy, task.OwnerId, task.ParentId, taskID, task.Status,
 	)
+	if err == nil && wasCompleted && !task.IsCompleted {
+		_, err = tx.Exec(r.Context(), "update task_users set completed_at = null where task_id = $1", taskID)
+	}
+	if err == nil {
+		task, err = api.fetchTask(r.Context(), tx, taskID, userID)
+	}
+	if err == nil {
+		err = tx.Commit(r.Context())
+	}
 	if err != nil {
-		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to complete task")
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to update task")
+		return
+	}
+	task.OpenBlockers = openBlockers
+
+	utils.WriteJSON(w, http.StatusOK, task)
+}
+
+func (api *API) deleteTaskHandler(w http.ResponseWriter, r *http.Request) {
+	taskID, ok := taskIDParam(w, r)
+	if !ok || !api.checkTaskAccess(w, r, taskID) {
+		return
+	}
+
+	tx, err := api.Pool.Begin(r.Context())
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to start transaction")
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	_, err = tx.Exec(r.Context(), "delete from task_users where task_id = $1", taskID)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to delete task")
+		return
+	}
+
+	var blobKeys []string
+	rows, err := tx.Query(r.Context(), "delete from task_attachments where task_id = $1 returning storage_key", taskID)
+	if err == nil {
+		blobKeys, err = pgx.CollectRows(rows, pgx.RowTo[string])
+	}
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to delete task")
+		return
+	}
+
+	tag, err := tx.Exec(r.Context(), "delete from tasks where id = $1", taskID)
+	if err == nil && tag.RowsAffected() == 0 {
+		utils.WriteJSONError(w, http.StatusNotFound, "not_found", "task with this id does not exist")
+		return
+	}
+	if err == nil {
+		err = tx.Commit(r.Context())
+	}
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to delete task")
 		return
 	}
+	api.deleteBlobs(context.WithoutCancel(r.Context()), blobKeys)
 
 	utils.WriteJSONSuccess(w, http.StatusOK)
 }
@@ -0,0 +1,299 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"rest-api/config"
+	"rest-api/internal/middlewares"
+	"rest-api/internal/models"
+	"rest-api/internal/rbac"
+	"rest-api/utils"
+)
+
+// taskHierarchyLock is the advisory lock key held while a task is moved to
+// another parent, so two concurrent moves cannot build a cycle together.
+const taskHierarchyLock = 7_201_024
+
+// maxTaskTreeDepth bounds the recursive queries over the hierarchy. Moves
+// that would build a deeper tree are refused, so the bound never cuts a
+// cycle check short.
+const maxTaskTreeDepth = 100
+
+var (
+	errTaskCycle       = errors.New("task cannot be nested under itself")
+	errTaskTooDeep     = errors.New("task tree is too deep")
+	errOpenSubtasks    = errors.New("task has open subtasks")
+	errCompletedParent = errors.New("parent task is completed")
+)
+
+// writeHierarchyError answers the errors of checkTaskParent,
+// checkSubtasksDone and checkParentOpen and reports whether err was one of
+// them.
+func writeHierarchyError(w http.ResponseWriter, err error) bool {
+	switch {
+	case errors.Is(err, errTaskCycle):
+		utils.WriteJSONError(w, http.StatusConflict, "task_cycle", "a task cannot be nested under itself or one of its subtasks")
+	case errors.Is(err, errTaskTooDeep):
+		utils.WriteJSONError(w, http.StatusConflict, "task_too_deep", fmt.Sprintf("task trees cannot be more than %d levels deep", maxTaskTreeDepth))
+	case errors.Is(err, errOpenSubtasks):
+		utils.WriteJSONError(w, http.StatusConflict, "open_subtasks", "a task cannot be completed while it has open subtasks")
+	case errors.Is(err, errCompletedParent):
+		utils.WriteJSONError(w, http.StatusConflict, "parent_completed", "an open task cannot be placed under a completed parent, reopen the parent first")
+	default:
+		return false
+	}
+	return true
+}
+
+// checkTaskParent validates a new parent for taskID, which is 0 for a task
+// that does not exist yet. The parent must be visible to the caller. Moving
+// an existing task must run in a transaction, which holds the hierarchy lock
+// until it ends.
+func (api *API) checkTaskParent(ctx context.Context, db dbtx, taskID int64, parentID *int64) error {
+	if parentID == nil {
+		return nil
+	}
+	userID, _ := ctx.Value(middlewares.UserIDKey).(int64)
+
+	if taskID != 0 {
+		_, err := db.Exec(ctx, "select pg_advisory_xact_lock($1)", taskHierarchyLock)
+		if err != nil {
+			return err
+		}
+	}
+
+	var exists, assigned bool
+	err := db.QueryRow(
+		ctx,
+		`select exists(select 1 from tasks where id = $1), `+taskVisibleTo("$1", "$2"),
+		*parentID, userID,
+	).Scan(&exists, &assigned)
+	if err != nil {
+		return err
+	}
+	if !exists || (!assigned && !middlewares.HasPermission(ctx, rbac.TasksReadAll)) {
+		return &utils.ValidationError{Field: "parent_id", Message: "task with this id does not exist"}
+	}
+	// The task lands one level below its parent, with its own subtasks
+	// below it; a task that does not exist yet has none.
+	var (
+		cycle               bool
+		parentLevel, height int
+	)
+	err = db.QueryRow(
+		ctx,
+		`with recursive ancestors(id, depth) as (
+		     select $1::bigint, 0
+		     union all
+		     select t.parent_id, a.depth + 1
+		     from tasks t join ancestors a on t.id = a.id
+		     where t.parent_id is not null and a.depth < $3
+		 ), descendants(id, depth) as (
+		     select $2::bigint, 0
+		     union all
+		     select t.id, d.depth + 1
+		     from tasks t join descendants d on t.parent_id = d.id
+		     where d.depth < $3
+		 )
+		 select exists(select 1 from ancestors where id = $2),
+		        (select max(depth) from ancestors),
+		        (select max(depth) from descendants)`,
+		*parentID, taskID, maxTaskTreeDepth,
+	).Scan(&cycle, &parentLevel, &height)
+	if err != nil {
+		return err
+	}
+	if cycle {
+		return errTaskCycle
+	}
+	if parentLevel+1+height >= maxTaskTreeDepth {
+		return errTaskTooDeep
+	}
+	return nil
+}
+
+// checkParentOpen enforces the subtask completion rule from the other side:
+// a task that is open after a move, a reopen or its creation cannot sit
+// under a completed parent.
+func (api *API) checkParentOpen(ctx context.Context, db dbtx, parentID *int64) error {
+	if parentID == nil || api.Config.SubtaskCompletion == config.SubtaskCompletionAllow {
+		return nil
+	}
+	var completed bool
+	err := db.QueryRow(ctx, "select is_completed from tasks where id = $1 for share", *parentID).Scan(&completed)
+	if err != nil {
+		return err
+	}
+	if completed {
+		return errCompletedParent
+	}
+	return nil
+}
+
+// checkSubtasksDone enforces the subtask completion rule before taskID is
+// completed.
+func (api *API) checkSubtasksDone(ctx context.Context, db dbtx, taskID int64) error {
+	if api.Config.SubtaskCompletion == config.SubtaskCompletionAllow {
+		return nil
+	}
+	var open bool
+	err := db.QueryRow(
+		ctx,
+		"select exists(select 1 from tasks where parent_id = $1 and not is_completed)",
+		taskID,
+	).Scan(&open)
+	if err != nil {
+		return err
+	}
+	if open {
+		return errOpenSubtasks
+	}
+	return nil
+}
+
+// getSubtasks lists the direct subtasks of a task with the filters, sorting
+// and pagination of GET /tasks.
+func (api *API) getSubtasks(w http.ResponseWriter, r *http.Request) {
+	taskID, ok := taskIDParam(w, r)
+	if !ok || !api.checkTaskAccess(w, r, taskID) {
+		return
+	}
+
+	query := &taskListQuery{sort: "id"}
+	err := query.parse(r.URL.Query())
+	if err != nil {
+		writeValidationError(w, err)
+		return
+	}
+	query.parent, query.rootsOnly = taskID, false
+	api.listTasks(w, r, query)
+}
+
+// getTaskTree returns a task with all its descendants. Subtasks the caller
+// cannot see are left out together with everything below them, but still
+// count towards the progress of their parent.
+func (api *API) getTaskTree(w http.ResponseWriter, r *http.Request) {
+	taskID, ok := taskIDParam(w, r)
+	if !ok || !api.checkTaskAccess(w, r, taskID) {
+		return
+	}
+	userID, _ := r.Context().Value(middlewares.UserIDKey).(int64)
+	readAll := middlewares.HasPermission(r.Context(), rbac.TasksReadAll)
+
+	rows, err := api.Pool.Query(
+		r.Context(),
+		`with recursive tree(id, parent_id, is_completed, depth) as (
+		     select id, parent_id, is_completed, 0 from tasks where id = $1
+		     union all
+		     select t.id, t.parent_id, t.is_completed, tree.depth + 1
+		     from tasks t join tree on t.parent_id = tree.id
+		     where tree.depth < $3
+		 )
+		 select id, parent_id, is_completed, `+taskVisibleTo("tree.id", "$2")+`
+		 from tree
+		 order by id`,
+		taskID, userID, maxTaskTreeDepth,
+	)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to fetch subtasks")
+		return
+	}
+	defer rows.Close()
+
+	var (
+		children  = map[int64][]int64{}
+		completed = map[int64]bool{}
+		visible   = map[int64]bool{}
+	)
+	for rows.Next() {
+		var (
+			id                int64
+			parentID          *int64
+			isDone, isVisible bool
+		)
+		err := rows.Scan(&id, &parentID, &isDone, &isVisible)
+		if err != nil {
+			utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to scan subtask row")
+			return
+		}
+		if id != taskID && parentID != nil {
+			children[*parentID] = append(children[*parentID], id)
+		}
+		completed[id] = isDone
+		visible[id] = isVisible || readAll || id == taskID
+	}
+	if rows.Err() != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to fetch subtasks")
+		return
+	}
+
+	// Rows come ordered by id, so children are listed oldest first. Only the
+	// tasks that end up in the response are loaded in full.
+	included := []int64{}
+	var collect func(id int64)
+	collect = func(id int64) {
+		included = append(included, id)
+		for _, child := range children[id] {
+			if visible[child] {
+				collect(child)
+			}
+		}
+	}
+	collect(taskID)
+
+	taskRows, err := api.Pool.Query(
+		r.Context(),
+		"select "+taskColumns("$1")+" from tasks t where t.id = any($2)",
+		userID, included,
+	)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to fetch subtasks")
+		return
+	}
+	defer taskRows.Close()
+
+	tasks := map[int64]models.Task{}
+	for taskRows.Next() {
+		var task models.Task
+		err := scanTask(taskRows, &task)
+		if err != nil {
+			utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to scan task row")
+			return
+		}
+		tasks[task.Id] = task
+	}
+	if taskRows.Err() != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to fetch subtasks")
+		return
+	}
+
+	var progress func(id int64) float64
+	progress = func(id int64) float64 {
+		if completed[id] {
+			return 1
+		}
+		if len(children[id]) == 0 {
+			return 0
+		}
+		sum := 0.0
+		for _, child := range children[id] {
+			sum += progress(child)
+		}
+		return sum / float64(len(children[id]))
+	}
+
+	var build func(id int64) models.TaskTreeNode
+	build = func(id int64) models.TaskTreeNode {
+		node := models.TaskTreeNode{Task: tasks[id], Progress: progress(id), Children: []models.TaskTreeNode{}}
+		for _, child := range children[id] {
+			if _, ok := tasks[child]; ok {
+				node.Children = append(node.Children, build(child))
+			}
+		}
+		return node
+	}
+
+	utils.WriteJSON(w, http.StatusOK, build(taskID))
+}
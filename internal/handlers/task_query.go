@@ -0,0 +1,324 @@
+package handlers
+
+import (
+	"fmt"
+	"net/url"
+	"rest-api/internal/models"
+	"rest-api/utils"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultTaskPageSize = 50
+	maxTaskPageSize     = 200
+)
+
+// taskColumns is the select list scanned by scanTask. viewer is the query
+// placeholder holding the viewing user's id, used for their own completion.
+func taskColumns(viewer string) string {
+	return `t.id, t.title, t.description, t.created_at, t.is_completed, t.status, t.priority, t.start_at, t.due_at,
+	t.completion_policy, t.owner_id,
+	(select completed_at from task_users where task_id = t.id and user_id = ` + viewer + `),
+	(select count(*) from task_users where task_id = t.id),
+	(select count(completed_at) from task_users where task_id = t.id),
+	t.parent_id,
+	(select count(*) from tasks where parent_id = t.id),
+	(select count(*) from tasks where parent_id = t.id and is_completed)`
+}
+
+// taskVisibleTo is the condition under which a caller without
+// tasks.read_all sees a task: they are assigned to it or own it. task and
+// viewer are the expressions holding the task id and the viewer's id.
+func taskVisibleTo(task, viewer string) string {
+	return `(exists(select 1 from task_users where task_id = ` + task + ` and user_id = ` + viewer + `)
+	         or exists(select 1 from tasks where id = ` + task + ` and owner_id = ` + viewer + `))`
+}
+
+func scanTask(row rowScanner, task *models.Task) error {
+	return row.Scan(
+		&task.Id,
+		&task.Title,
+		&task.Description,
+		&task.CreatedAt,
+		&task.IsCompleted,
+		&task.Status,
+		&task.Priority,
+		&task.StartAt,
+		&task.DueAt,
+		&task.CompletionPolicy,
+		&task.OwnerId,
+		&task.MyCompletedAt,
+		&task.AssigneeCount,
+		&task.CompletedCount,
+		&task.ParentId,
+		&task.SubtaskCount,
+		&task.CompletedSubtaskCount,
+	)
+}
+
+var priorityRank = "array_position(array['" + strings.Join(utils.TaskPriorities, "', '") + "'], t.priority)"
+
+type taskSortKey struct {
+	column string
+	// nullable columns sort their nulls last in both directions.
+	nullable bool
+	// kind tells how the cursor value is parsed back: time, int or text.
+	kind   string
+	cursor func(task models.Task) string
+}
+
+var taskSortKeys = map[string]taskSortKey{
+	"id": {column: "t.id"},
+	"created_at": {column: "t.created_at", kind: "time", cursor: func(task models.Task) string {
+		return task.CreatedAt.Format(time.RFC3339Nano)
+	}},
+	"title": {column: "t.title", kind: "text", cursor: func(task models.Task) string {
+		return task.Title
+	}},
+	"priority": {column: priorityRank, kind: "int", cursor: func(task models.Task) string {
+		for i, p := range utils.TaskPriorities {
+			if p == task.Priority {
+				return strconv.Itoa(i + 1)
+			}
+		}
+		return "0"
+	}},
+	"start_at": {column: "t.start_at", nullable: true, kind: "time", cursor: func(task models.Task) string {
+		return formatOptionalTime(task.StartAt)
+	}},
+	"due_at": {column: "t.due_at", nullable: true, kind: "time", cursor: func(task models.Task) string {
+		return formatOptionalTime(task.DueAt)
+	}},
+}
+
+func formatOptionalTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+type taskListQuery struct {
+	status        string
+	createdAfter  *time.Time
+	createdBefore *time.Time
+	dueAfter      *time.Time
+	dueBefore     *time.Time
+	priority      string
+	overdue       bool
+	assignee      int64
+	parent        int64
+	rootsOnly     bool
+	text          string
+	sort          string
+	desc          bool
+	limit         int
+	after         *listCursor
+}
+
+func parseTimeParam(query url.Values, field string) (*time.Time, error) {
+	value := query.Get(field)
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, &utils.ValidationError{Field: field, Message: field + " must be an RFC 3339 timestamp"}
+	}
+	return &t, nil
+}
+
+func parseTaskListQuery(query url.Values) (*taskListQuery, error) {
+	q := &taskListQuery{sort: "created_at", desc: true}
+	return q, q.parse(query)
+}
+
+func (q *taskListQuery) parse(query url.Values) error {
+	// status is open, completed or the name of a workflow status.
+	q.status = query.Get("status")
+
+	var err error
+	for field, dst := range map[string]**time.Time{
+		"created_after":  &q.createdAfter,
+		"created_before": &q.createdBefore,
+		"due_after":      &q.dueAfter,
+		"due_before":     &q.dueBefore,
+	} {
+		*dst, err = parseTimeParam(query, field)
+		if err != nil {
+			return err
+		}
+	}
+
+	if value := query.Get("priority"); value != "" {
+		err = utils.ValidateTaskPlanning(value, nil, nil)
+		if err != nil {
+			return err
+		}
+		q.priority = value
+	}
+
+	if value := query.Get("assignee"); value != "" {
+		q.assignee, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || q.assignee <= 0 {
+			return &utils.ValidationError{Field: "assignee", Message: "assignee must be a positive integer"}
+		}
+	}
+
+	// parent_id is a task id, or none for top-level tasks only.
+	switch value := query.Get("parent_id"); value {
+	case "":
+	case "none":
+		q.rootsOnly = true
+	default:
+		q.parent, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || q.parent <= 0 {
+			return &utils.ValidationError{Field: "parent_id", Message: "parent_id must be a positive integer or none"}
+		}
+	}
+
+	q.text = strings.TrimSpace(query.Get("q"))
+
+	if value := query.Get("sort"); value != "" {
+		if _, ok := taskSortKeys[value]; !ok {
+			return &utils.ValidationError{Field: "sort", Message: "sort must be one of id, created_at, title, priority, start_at, due_at"}
+		}
+		q.sort = value
+	}
+	switch query.Get("order") {
+	case "":
+	case "asc":
+		q.desc = false
+	case "desc":
+		q.desc = true
+	default:
+		return &utils.ValidationError{Field: "order", Message: "order must be asc or desc"}
+	}
+
+	q.limit, err = parseLimitParam(query, defaultTaskPageSize, maxTaskPageSize)
+	if err != nil {
+		return err
+	}
+
+	if value := query.Get("cursor"); value != "" {
+		q.after, err = decodeCursor(value)
+		if err != nil || q.after.Sort != q.sort || q.after.Desc != q.desc {
+			return &utils.ValidationError{Field: "cursor", Message: "cursor is invalid or does not match the requested sort"}
+		}
+	}
+	return nil
+}
+
+// sql builds the page query for viewerID. onlyAssigned limits the result to
+// tasks the viewer is assigned to, for callers without tasks.read_all.
+func (q *taskListQuery) sql(viewerID int64, onlyAssigned bool) (string, []any, error) {
+	conditions := []string{"true"}
+	var args []any
+	arg := func(value any) string {
+		args = append(args, value)
+		return "$" + strconv.Itoa(len(args))
+	}
+	viewer := arg(viewerID)
+
+	if onlyAssigned {
+		conditions = append(conditions, taskVisibleTo("t.id", viewer))
+	}
+	if q.assignee != 0 {
+		conditions = append(conditions, "exists(select 1 from task_users where task_id = t.id and user_id = "+arg(q.assignee)+")")
+	}
+	if q.parent != 0 {
+		conditions = append(conditions, "t.parent_id = "+arg(q.parent))
+	}
+	if q.rootsOnly {
+		conditions = append(conditions, "t.parent_id is null")
+	}
+	switch q.status {
+	case "open":
+		conditions = append(conditions, "not t.is_completed")
+	case "completed":
+		conditions = append(conditions, "t.is_completed")
+	case "":
+	default:
+		conditions = append(conditions, "t.status = "+arg(q.status))
+	}
+	if q.overdue {
+		conditions = append(conditions, "not t.is_completed and t.due_at < now()")
+	}
+	if q.createdAfter != nil {
+		conditions = append(conditions, "t.created_at >= "+arg(*q.createdAfter))
+	}
+	if q.createdBefore != nil {
+		conditions = append(conditions, "t.created_at < "+arg(*q.createdBefore))
+	}
+	if q.dueAfter != nil {
+		conditions = append(conditions, "t.due_at >= "+arg(*q.dueAfter))
+	}
+	if q.dueBefore != nil {
+		conditions = append(conditions, "t.due_at < "+arg(*q.dueBefore))
+	}
+	if q.priority != "" {
+		conditions = append(conditions, "t.priority = "+arg(q.priority))
+	}
+	if q.text != "" {
+		conditions = append(conditions, "t.title ilike "+arg(likePattern(q.text)))
+	}
+
+	key := taskSortKeys[q.sort]
+	direction, compare, sentinel := "asc", ">", "'infinity'::timestamptz"
+	if q.desc {
+		direction, compare, sentinel = "desc", "<", "'-infinity'::timestamptz"
+	}
+	column := key.column
+	if key.nullable {
+		column = fmt.Sprintf("coalesce(%s, %s)", key.column, sentinel)
+	}
+
+	if q.after != nil {
+		if q.sort == "id" {
+			conditions = append(conditions, "t.id "+compare+" "+arg(q.after.Id))
+		} else {
+			value, err := key.cursorArg(q.after.Value)
+			if err != nil {
+				return "", nil, &utils.ValidationError{Field: "cursor", Message: "cursor is invalid or does not match the requested sort"}
+			}
+			placeholder := arg(value)
+			if key.nullable {
+				placeholder = fmt.Sprintf("coalesce(%s::timestamptz, %s)", placeholder, sentinel)
+			}
+			conditions = append(conditions, fmt.Sprintf("(%s, t.id) %s (%s, %s)", column, compare, placeholder, arg(q.after.Id)))
+		}
+	}
+
+	order := column + " " + direction
+	if q.sort != "id" {
+		order += ", t.id " + direction
+	}
+
+	sql := "select " + taskColumns(viewer) + " from tasks t where " + strings.Join(conditions, " and ") +
+		" order by " + order + " limit " + arg(q.limit+1)
+	return sql, args, nil
+}
+
+func (k taskSortKey) cursorArg(value string) (any, error) {
+	switch k.kind {
+	case "time":
+		if value == "" && k.nullable {
+			return nil, nil
+		}
+		return time.Parse(time.RFC3339Nano, value)
+	case "int":
+		return strconv.Atoi(value)
+	default:
+		return value, nil
+	}
+}
+
+func (q *taskListQuery) cursorAfter(task models.Task) string {
+	c := listCursor{Sort: q.sort, Desc: q.desc, Id: task.Id}
+	if key := taskSortKeys[q.sort]; key.cursor != nil {
+		c.Value = key.cursor(task)
+	}
+	return c.encode()
+}
@@ -0,0 +1,424 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"rest-api/internal/middlewares"
+	"rest-api/internal/models"
+	"rest-api/internal/rbac"
+	"rest-api/utils"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// initialStatus and completedStatus are where tasks created with
+// is_completed start: the first open and the first terminal status of the
+// workflow. completedStatus is also the target of later is_completed
+// changes.
+const (
+	initialStatus   = `(select name from task_statuses where not terminal order by position, name limit 1)`
+	completedStatus = `(select name from task_statuses where terminal order by position, name limit 1)`
+)
+
+// transitionError rejects a move along the workflow with the response
+// that explains why.
+type transitionError struct {
+	status  int
+	code    string
+	message string
+}
+
+func (e *transitionError) Error() string { return e.message }
+
+// writeTransitionError answers a *transitionError and reports whether err
+// was one.
+func writeTransitionError(w http.ResponseWriter, err error) bool {
+	var transitionErr *transitionError
+	if !errors.As(err, &transitionErr) {
+		return false
+	}
+	utils.WriteJSONError(w, transitionErr.status, transitionErr.code, transitionErr.message)
+	return true
+}
+
+// legacyTransition resolves an is_completed change of a task in status from
+// to a status of the workflow, see resolveLegacyTransition.
+func (api *API) legacyTransition(ctx context.Context, db dbtx, from string, complete bool) (string, error) {
+	workflow, err := api.fetchTaskWorkflow(ctx, db)
+	if err != nil {
+		return "", err
+	}
+	return resolveLegacyTransition(workflow, from, complete, func(permission string) bool {
+		return middlewares.HasPermission(ctx, permission)
+	})
+}
+
+// resolveLegacyTransition keeps is_completed working for old clients.
+// Completing always moves to the first terminal status: through the
+// configured transition and its permission when there is one, otherwise
+// straight there with tasks.complete. Reopening takes the first open status
+// reachable from the current one that allowed permits.
+func resolveLegacyTransition(workflow *models.TaskWorkflow, from string, complete bool, allowed func(permission string) bool) (string, error) {
+	var candidates []models.TaskTransition
+	if complete {
+		target := ""
+		for _, status := range workflow.Statuses {
+			if status.Terminal {
+				target = status.Name
+				break
+			}
+		}
+		transition := models.TaskTransition{From: from, To: target, Permission: rbac.TasksComplete}
+		for _, t := range workflow.Transitions {
+			if t.From == from && t.To == target {
+				transition = t
+			}
+		}
+		if target != "" {
+			candidates = append(candidates, transition)
+		}
+	} else {
+		// Statuses come ordered by position, so the first match wins.
+		for _, status := range workflow.Statuses {
+			if status.Terminal {
+				continue
+			}
+			for _, t := range workflow.Transitions {
+				if t.From == from && t.To == status.Name {
+					candidates = append(candidates, t)
+				}
+			}
+		}
+	}
+
+	if len(candidates) == 0 {
+		target := "an open status"
+		if complete {
+			target = "completion"
+		}
+		return "", &transitionError{
+			status:  http.StatusConflict,
+			code:    "invalid_transition",
+			message: fmt.Sprintf("cannot move a task from %s to %s", from, target),
+		}
+	}
+	for _, transition := range candidates {
+		if allowed(transition.Permission) {
+			return transition.To, nil
+		}
+	}
+	return "", &transitionError{
+		status:  http.StatusForbidden,
+		code:    "forbidden",
+		message: "missing permission " + candidates[0].Permission,
+	}
+}
+
+func (api *API) fetchTaskWorkflow(ctx context.Context, db dbtx) (*models.TaskWorkflow, error) {
+	workflow := &models.TaskWorkflow{
+		Statuses:    []models.TaskStatus{},
+		Transitions: []models.TaskTransition{},
+	}
+
+	rows, err := db.Query(ctx, "select name, position, terminal from task_statuses order by position, name")
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var status models.TaskStatus
+		err := rows.Scan(&status.Name, &status.Position, &status.Terminal)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		workflow.Statuses = append(workflow.Statuses, status)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.Query(ctx, "select from_status, to_status, permission from task_transitions order by from_status, to_status")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var transition models.TaskTransition
+		err := rows.Scan(&transition.From, &transition.To, &transition.Permission)
+		if err != nil {
+			return nil, err
+		}
+		workflow.Transitions = append(workflow.Transitions, transition)
+	}
+	return workflow, rows.Err()
+}
+
+func (api *API) getTaskWorkflow(w http.ResponseWriter, r *http.Request) {
+	workflow, err := api.fetchTaskWorkflow(r.Context(), api.Pool)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to fetch workflow")
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, workflow)
+}
+
+func validateTaskWorkflow(workflow *models.TaskWorkflow) error {
+	statuses := map[string]bool{}
+	hasOpen, hasTerminal := false, false
+	for i, status := range workflow.Statuses {
+		name := strings.TrimSpace(status.Name)
+		if name == "" {
+			return &utils.ValidationError{Field: "statuses", Message: fmt.Sprintf("statuses[%d].name is required", i)}
+		}
+		if statuses[name] {
+			return &utils.ValidationError{Field: "statuses", Message: "duplicate status " + name}
+		}
+		statuses[name] = true
+		workflow.Statuses[i].Name = name
+		if status.Terminal {
+			hasTerminal = true
+		} else {
+			hasOpen = true
+		}
+	}
+	if !hasOpen || !hasTerminal {
+		return &utils.ValidationError{Field: "statuses", Message: "workflow needs at least one open and one terminal status"}
+	}
+
+	for i, transition := range workflow.Transitions {
+		if !statuses[transition.From] || !statuses[transition.To] {
+			return &utils.ValidationError{Field: "transitions", Message: fmt.Sprintf("transitions[%d] refers to an unknown status", i)}
+		}
+		if transition.From == transition.To {
+			return &utils.ValidationError{Field: "transitions", Message: fmt.Sprintf("transitions[%d] does not change the status", i)}
+		}
+		if transition.Permission == "" {
+			workflow.Transitions[i].Permission = rbac.TasksUpdate
+		} else if transition.Permission == rbac.Wildcard || !rbac.IsKnown(transition.Permission) {
+			return &utils.ValidationError{Field: "transitions", Message: "unknown permission " + transition.Permission}
+		}
+	}
+	return nil
+}
+
+// replaceTaskWorkflow swaps in a new state machine. Statuses still used by
+// tasks cannot be dropped, and is_completed is re-synced when a status
+// changes between open and terminal.
+func (api *API) replaceTaskWorkflow(w http.ResponseWriter, r *http.Request) {
+	var workflow models.TaskWorkflow
+	if !readJSON(w, r, &workflow) {
+		return
+	}
+	err := validateTaskWorkflow(&workflow)
+	if err != nil {
+		writeValidationError(w, err)
+		return
+	}
+
+	tx, err := api.Pool.Begin(r.Context())
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to start transaction")
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	_, err = tx.Exec(r.Context(), "lock table task_statuses in exclusive mode")
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to lock workflow")
+		return
+	}
+
+	names := make([]string, 0, len(workflow.Statuses))
+	for _, status := range workflow.Statuses {
+		names = append(names, status.Name)
+		_, err = tx.Exec(
+			r.Context(),
+			`insert into task_statuses(name, position, terminal) values ($1, $2, $3)
+			 on conflict (name) do update set position = excluded.position, terminal = excluded.terminal`,
+			status.Name, status.Position, status.Terminal,
+		)
+		if err != nil {
+			utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to save workflow")
+			return
+		}
+	}
+
+	var inUse string
+	err = tx.QueryRow(
+		r.Context(),
+		"select status from tasks where not (status = any($1)) limit 1",
+		names,
+	).Scan(&inUse)
+	if err == nil {
+		utils.WriteJSONError(w, http.StatusConflict, "status_in_use", "status "+inUse+" is still used by tasks")
+		return
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to check status usage")
+		return
+	}
+
+	statements := []struct {
+		sql  string
+		args []any
+	}{
+		{"delete from task_transitions", nil},
+		{"delete from task_statuses where not (name = any($1))", []any{names}},
+		{
+			`update tasks t set is_completed = s.terminal
+			 from task_statuses s
+			 where s.name = t.status and t.is_completed <> s.terminal`,
+			nil,
+		},
+	}
+	for _, statement := range statements {
+		_, err = tx.Exec(r.Context(), statement.sql, statement.args...)
+		if err != nil {
+			utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to save workflow")
+			return
+		}
+	}
+
+	for _, transition := range workflow.Transitions {
+		_, err = tx.Exec(
+			r.Context(),
+			"insert into task_transitions(from_status, to_status, permission) values ($1, $2, $3) on conflict do nothing",
+			transition.From, transition.To, transition.Permission,
+		)
+		if err != nil {
+			utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to save workflow")
+			return
+		}
+	}
+
+	saved, err := api.fetchTaskWorkflow(r.Context(), tx)
+	if err == nil {
+		err = tx.Commit(r.Context())
+	}
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to save workflow")
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, saved)
+}
+
+// transitionTaskHandler moves a task along the workflow. The transition has
+// to exist and the caller needs the permission attached to it.
+func (api *API) transitionTaskHandler(w http.ResponseWriter, r *http.Request) {
+	taskID, ok := taskIDParam(w, r)
+	if !ok || !api.checkTaskAccess(w, r, taskID) {
+		return
+	}
+
+	var req models.TaskTransitionRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	req.To = strings.TrimSpace(req.To)
+	if req.To == "" {
+		utils.WriteJSONValidationError(w, "to", "to is required")
+		return
+	}
+
+	tx, err := api.Pool.Begin(r.Context())
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to start transaction")
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	var (
+		from         string
+		wasCompleted bool
+		parentID     *int64
+	)
+	err = tx.QueryRow(
+		r.Context(),
+		"select status, is_completed, parent_id from tasks where id = $1 for update",
+		taskID,
+	).Scan(&from, &wasCompleted, &parentID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		utils.WriteJSONError(w, http.StatusNotFound, "not_found", "task with this id does not exist")
+		return
+	}
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to fetch task")
+		return
+	}
+
+	var permission string
+	err = tx.QueryRow(
+		r.Context(),
+		"select permission from task_transitions where from_status = $1 and to_status = $2",
+		from, req.To,
+	).Scan(&permission)
+	if errors.Is(err, pgx.ErrNoRows) {
+		utils.WriteJSONError(w, http.StatusConflict, "invalid_transition", fmt.Sprintf("cannot move a task from %s to %s", from, req.To))
+		return
+	}
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to check transition")
+		return
+	}
+	if !middlewares.HasPermission(r.Context(), permission) {
+		utils.WriteJSONError(w, http.StatusForbidden, "forbidden", "missing permission "+permission)
+		return
+	}
+
+	var terminal bool
+	err = tx.QueryRow(
+		r.Context(),
+		`update tasks t set status = s.name, is_completed = s.terminal
+		 from task_statuses s
+		 where t.id = $1 and s.name = $2
+		 returning s.terminal`,
+		taskID, req.To,
+	).Scan(&terminal)
+	if err == nil && wasCompleted && !terminal {
+		err = api.checkParentOpen(r.Context(), tx, parentID)
+	}
+	if err == nil && wasCompleted && !terminal {
+		_, err = tx.Exec(r.Context(), "update task_users set completed_at = null where task_id = $1", taskID)
+	}
+	// Moving into a terminal status counts as the caller's completion and
+	// is refused while the completion policy is not met.
+	userID, _ := r.Context().Value(middlewares.UserIDKey).(int64)
+	if err == nil && !wasCompleted && terminal {
+		var done bool
+		done, err = api.recordCompletion(r.Context(), tx, taskID, userID)
+		if err == nil && !done {
+			utils.WriteJSONError(w, http.StatusConflict, "completion_pending",
+				"the completion policy of this task is not met yet, record your part with POST /tasks/{id}/complete")
+			return
+		}
+	}
+	var openBlockers []int64
+	if err == nil && !wasCompleted && terminal {
+		err = api.checkSubtasksDone(r.Context(), tx, taskID)
+		if err == nil {
+			openBlockers, err = api.checkBlockersDone(r.Context(), tx, taskID)
+		}
+	}
+	if writeHierarchyError(w, err) || writeDependencyError(w, err, openBlockers) {
+		return
+	}
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to update task")
+		return
+	}
+
+	task, err := api.fetchTask(r.Context(), tx, taskID, userID)
+	if err == nil {
+		err = tx.Commit(r.Context())
+	}
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to update task")
+		return
+	}
+	task.OpenBlockers = openBlockers
+	utils.WriteJSON(w, http.StatusOK, task)
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"rest-api/internal/models"
+	"rest-api/internal/rbac"
+	"testing"
+)
+
+// defaultWorkflow mirrors the workflow seeded by 017_task_workflow.sql, in
+// the order fetchTaskWorkflow returns it.
+func defaultWorkflow() *models.TaskWorkflow {
+	return &models.TaskWorkflow{
+		Statuses: []models.TaskStatus{
+			{Name: "todo", Position: 0},
+			{Name: "in_progress", Position: 1},
+			{Name: "review", Position: 2},
+			{Name: "done", Position: 3, Terminal: true},
+			{Name: "cancelled", Position: 4, Terminal: true},
+		},
+		Transitions: []models.TaskTransition{
+			{From: "cancelled", To: "todo", Permission: rbac.TasksUpdate},
+			{From: "done", To: "in_progress", Permission: rbac.TasksUpdate},
+			{From: "in_progress", To: "cancelled", Permission: rbac.TasksDelete},
+			{From: "in_progress", To: "review", Permission: rbac.TasksUpdate},
+			{From: "in_progress", To: "todo", Permission: rbac.TasksUpdate},
+			{From: "review", To: "done", Permission: rbac.TasksComplete},
+			{From: "review", To: "in_progress", Permission: rbac.TasksUpdate},
+			{From: "todo", To: "cancelled", Permission: rbac.TasksDelete},
+			{From: "todo", To: "in_progress", Permission: rbac.TasksUpdate},
+		},
+	}
+}
+
+func grants(permissions ...string) func(string) bool {
+	set := rbac.Set{}
+	for _, p := range permissions {
+		set[p] = true
+	}
+	return set.Has
+}
+
+// A freshly created task sits in the first open status, which has no edge
+// into done. Old clients completing it must still succeed.
+func TestLegacyCompletionOfNewTask(t *testing.T) {
+	workflow := defaultWorkflow()
+	initial := workflow.Statuses[0].Name
+
+	to, err := resolveLegacyTransition(workflow, initial, true, grants(rbac.TasksComplete))
+	if err != nil || to != "done" {
+		t.Fatalf("complete from %s: got %q, %v, want done", initial, to, err)
+	}
+
+	for _, from := range []string{"in_progress", "review"} {
+		to, err := resolveLegacyTransition(workflow, from, true, grants(rbac.TasksComplete))
+		if err != nil || to != "done" {
+			t.Errorf("complete from %s: got %q, %v, want done", from, to, err)
+		}
+	}
+}
+
+func TestLegacyCompletionNeedsPermission(t *testing.T) {
+	_, err := resolveLegacyTransition(defaultWorkflow(), "todo", true, grants(rbac.TasksUpdate))
+	var transitionErr *transitionError
+	if !errors.As(err, &transitionErr) || transitionErr.status != http.StatusForbidden {
+		t.Fatalf("got %v, want a 403 transition error", err)
+	}
+}
+
+func TestLegacyReopen(t *testing.T) {
+	workflow := defaultWorkflow()
+
+	tests := []struct {
+		from string
+		want string
+	}{
+		{"done", "in_progress"},
+		{"cancelled", "todo"},
+	}
+	for _, tt := range tests {
+		to, err := resolveLegacyTransition(workflow, tt.from, false, grants(rbac.TasksUpdate))
+		if err != nil || to != tt.want {
+			t.Errorf("reopen from %s: got %q, %v, want %s", tt.from, to, err, tt.want)
+		}
+	}
+
+	workflow.Transitions = workflow.Transitions[2:]
+	_, err := resolveLegacyTransition(workflow, "done", false, grants(rbac.Wildcard))
+	var transitionErr *transitionError
+	if !errors.As(err, &transitionErr) || transitionErr.status != http.StatusConflict {
+		t.Errorf("reopen without a transition: got %v, want a 409 transition error", err)
+	}
+}
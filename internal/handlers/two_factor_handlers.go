@@ -0,0 +1,614 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"rest-api/internal/audit"
+	"rest-api/internal/middlewares"
+	"rest-api/internal/models"
+	"rest-api/utils"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	recoveryCodeCount           = 10
+	maxTwoFactorChallengeErrors = 5
+)
+
+func (api *API) RegisterTwoFactor(r chi.Router) {
+	r.Group(func(gr chi.Router) {
+		gr.Use(middlewares.AuthCheck(api.Pool, api.Config))
+		gr.Use(middlewares.RequireSession)
+		gr.Use(middlewares.NoImpersonation)
+		gr.Post("/auth/2fa/enroll", api.enrollTwoFactor)
+		gr.Post("/auth/2fa/confirm", api.confirmTwoFactor)
+		gr.Post("/auth/2fa/disable", api.disableTwoFactor)
+		gr.Post("/auth/2fa/recovery-codes", api.regenerateRecoveryCodes)
+	})
+	r.Post("/auth/login/2fa", api.loginTwoFactorHandler)
+}
+
+func (api *API) startTwoFactorChallenge(w http.ResponseWriter, r *http.Request, userID int64, withRefresh bool) {
+	token, err := utils.GenerateSessionToken()
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "token_generation_failed", "failed to create token")
+		return
+	}
+
+	expiresAt := time.Now().Add(api.Config.TwoFactorChallengeTTL)
+	_, err = api.Pool.Exec(
+		r.Context(),
+		"insert into two_factor_challenges(user_id, token_hash, key_id, expires_at, issue_refresh_token) values ($1, $2, $3, $4, $5)",
+		userID, utils.HashTokenHMAC(token), utils.CurrentKeyID(), expiresAt, withRefresh,
+	)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to save two-factor challenge")
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, models.TwoFactorChallengeResponse{
+		Status:         "2fa_required",
+		ChallengeToken: token,
+		ExpiresAt:      expiresAt,
+	})
+}
+
+func (api *API) loginTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	var req models.TwoFactorLoginRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if strings.TrimSpace(req.ChallengeToken) == "" {
+		utils.WriteJSONValidationError(w, "challenge_token", "challenge_token is required")
+		return
+	}
+	if strings.TrimSpace(req.Code) == "" && strings.TrimSpace(req.RecoveryCode) == "" {
+		utils.WriteJSONValidationError(w, "code", "code or recovery_code is required")
+		return
+	}
+
+	tx, err := api.Pool.Begin(r.Context())
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to start transaction")
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	var (
+		challengeID int64
+		userID      int64
+		expiresAt   time.Time
+		attempts    int
+		withRefresh bool
+	)
+	err = tx.QueryRow(
+		r.Context(),
+		`select id, user_id, expires_at, attempts, issue_refresh_token
+		 from two_factor_challenges where token_hash = any($1)
+		 for update`,
+		utils.TokenHashes(req.ChallengeToken),
+	).Scan(&challengeID, &userID, &expiresAt, &attempts, &withRefresh)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusUnauthorized, "invalid_challenge", "two-factor challenge is invalid or expired")
+		return
+	}
+
+	if !time.Now().Before(expiresAt) {
+		tx.Exec(r.Context(), "delete from two_factor_challenges where id = $1", challengeID)
+		tx.Commit(r.Context())
+		utils.WriteJSONError(w, http.StatusUnauthorized, "invalid_challenge", "two-factor challenge is invalid or expired")
+		return
+	}
+
+	// Wrong codes count against the same per-login throttle as wrong
+	// passwords, so opening fresh challenges does not reset the budget.
+	var login string
+	err = tx.QueryRow(r.Context(), "select login from users where id = $1", userID).Scan(&login)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to fetch user info")
+		return
+	}
+	loginKey := loginAttemptKey(login)
+	lockedUntil, err := api.loginBlockedUntil(r.Context(), loginKey)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to check login attempts")
+		return
+	}
+	if !lockedUntil.IsZero() {
+		utils.WriteJSONRetryAfter(w, time.Until(lockedUntil), "too_many_attempts", "too many failed login attempts, try again later")
+		return
+	}
+
+	valid, err := api.verifySecondFactor(r.Context(), tx, userID, req.Code, req.RecoveryCode)
+	if err != nil {
+		writeSecondFactorError(w, err)
+		return
+	}
+
+	if !valid {
+		err = api.recordLoginFailure(r.Context(), loginKey, api.Config.LoginMaxFailures)
+		if err != nil {
+			fmt.Println("database : ", err)
+		}
+
+		if attempts+1 >= maxTwoFactorChallengeErrors {
+			_, err = tx.Exec(r.Context(), "delete from two_factor_challenges where id = $1", challengeID)
+		} else {
+			_, err = tx.Exec(r.Context(), "update two_factor_challenges set attempts = attempts + 1 where id = $1", challengeID)
+		}
+		if err == nil {
+			err = tx.Commit(r.Context())
+		}
+		if err != nil {
+			utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to update two-factor challenge")
+			return
+		}
+		utils.WriteJSONError(w, http.StatusUnauthorized, "invalid_code", "two-factor code is incorrect")
+		return
+	}
+
+	_, err = tx.Exec(r.Context(), "delete from two_factor_challenges where id = $1", challengeID)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to delete two-factor challenge")
+		return
+	}
+
+	var profile models.UserProfileResponse
+	err = tx.QueryRow(
+		r.Context(),
+		"select id, family, name, surname, "+isAdminColumn+" from users where id = $1",
+		userID,
+	).Scan(
+		&profile.Id,
+		&profile.Family,
+		&profile.Name,
+		&profile.Surname,
+		&profile.IsAdmin,
+	)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to fetch user info")
+		return
+	}
+
+	err = tx.Commit(r.Context())
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to commit transaction")
+		return
+	}
+
+	err = api.clearLoginFailures(r.Context(), loginKey)
+	if err != nil {
+		fmt.Println("database : ", err)
+	}
+
+	api.issueLogin(w, r, profile, withRefresh)
+}
+
+// writeSecondFactorError answers an error of verifySecondFactor. A secret
+// sealed under a key that has left the keyring cannot be read anymore; the
+// user can still sign in with a recovery code, or have an admin reset 2FA.
+func writeSecondFactorError(w http.ResponseWriter, err error) {
+	if errors.Is(err, utils.ErrSecretKeyUnknown) {
+		utils.WriteJSONError(w, http.StatusConflict, "two_factor_unavailable",
+			"the two-factor secret can no longer be read, use a recovery code or ask an administrator to reset two-factor authentication")
+		return
+	}
+	utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to verify two-factor code")
+}
+
+// resetTwoFactor lets an admin turn off 2FA for a user who can no longer
+// complete it, for example after their secret's key was retired. The user
+// has to enroll again.
+func (api *API) resetTwoFactor(w http.ResponseWriter, r *http.Request) {
+	actorID, _ := r.Context().Value(middlewares.UserIDKey).(int64)
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || id <= 0 {
+		utils.WriteJSONError(w, http.StatusBadRequest, "invalid_id", "user id must be a positive integer")
+		return
+	}
+
+	tx, err := api.Pool.Begin(r.Context())
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to start transaction")
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	tag, err := tx.Exec(
+		r.Context(),
+		"update users set totp_enabled = false, totp_secret = null, totp_last_step = 0 where id = $1",
+		id,
+	)
+	if err == nil && tag.RowsAffected() == 0 {
+		utils.WriteJSONError(w, http.StatusNotFound, "not_found", "user with this id does not exist")
+		return
+	}
+	statements := []string{
+		"delete from recovery_codes where user_id = $1",
+		"delete from two_factor_challenges where user_id = $1",
+	}
+	for _, sql := range statements {
+		if err != nil {
+			break
+		}
+		_, err = tx.Exec(r.Context(), sql, id)
+	}
+	if err == nil {
+		err = audit.Record(r.Context(), tx, audit.Entry{
+			ActorID: actorID,
+			UserID:  id,
+			Action:  audit.ActionTwoFactorReset,
+			IP:      utils.ClientIP(r),
+		})
+	}
+	if err == nil {
+		err = tx.Commit(r.Context())
+	}
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to reset two-factor authentication")
+		return
+	}
+	utils.WriteJSONSuccess(w, http.StatusOK)
+}
+
+// checkSecondFactor runs verifySecondFactor for a signed-in user behind the
+// same per-login throttle as loginTwoFactorHandler, and answers the request
+// itself unless the code is accepted.
+func (api *API) checkSecondFactor(w http.ResponseWriter, r *http.Request, db dbtx, userID int64, login, code, recoveryCode string) bool {
+	loginKey := loginAttemptKey(login)
+	lockedUntil, err := api.loginBlockedUntil(r.Context(), loginKey)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to check login attempts")
+		return false
+	}
+	if !lockedUntil.IsZero() {
+		utils.WriteJSONRetryAfter(w, time.Until(lockedUntil), "too_many_attempts", "too many failed two-factor attempts, try again later")
+		return false
+	}
+
+	valid, err := api.verifySecondFactor(r.Context(), db, userID, code, recoveryCode)
+	if err != nil {
+		writeSecondFactorError(w, err)
+		return false
+	}
+	if !valid {
+		err = api.recordLoginFailure(r.Context(), loginKey, api.Config.LoginMaxFailures)
+		if err != nil {
+			fmt.Println("database : ", err)
+		}
+		utils.WriteJSONError(w, http.StatusUnauthorized, "invalid_code", "two-factor code is incorrect")
+		return false
+	}
+
+	err = api.clearLoginFailures(r.Context(), loginKey)
+	if err != nil {
+		fmt.Println("database : ", err)
+	}
+	return true
+}
+
+// verifySecondFactor accepts either a TOTP code or an unused recovery code.
+// A TOTP step is accepted only once, and a recovery code is burned on use.
+func (api *API) verifySecondFactor(ctx context.Context, db dbtx, userID int64, code, recoveryCode string) (bool, error) {
+	if strings.TrimSpace(recoveryCode) != "" {
+		tag, err := db.Exec(
+			ctx,
+			"update recovery_codes set used_at = now() where user_id = $1 and code_hash = any($2) and used_at is null",
+			userID, utils.TokenHashes(utils.NormalizeRecoveryCode(recoveryCode)),
+		)
+		if err != nil {
+			return false, err
+		}
+		return tag.RowsAffected() == 1, nil
+	}
+
+	var (
+		secret   *string
+		lastStep int64
+	)
+	err := db.QueryRow(
+		ctx,
+		"select totp_secret, totp_last_step from users where id = $1 for update",
+		userID,
+	).Scan(&secret, &lastStep)
+	if err != nil {
+		return false, err
+	}
+	if secret == nil {
+		return false, nil
+	}
+	plainSecret, err := utils.DecryptSecret(*secret)
+	if err != nil {
+		return false, err
+	}
+
+	step, ok := utils.ValidateTOTP(plainSecret, code, time.Now())
+	if !ok || step <= lastStep {
+		return false, nil
+	}
+
+	_, err = db.Exec(ctx, "update users set totp_last_step = $1 where id = $2", step, userID)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (api *API) replaceRecoveryCodes(ctx context.Context, db dbtx, userID int64) ([]string, error) {
+	_, err := db.Exec(ctx, "delete from recovery_codes where user_id = $1", userID)
+	if err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := utils.GenerateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		_, err = db.Exec(
+			ctx,
+			"insert into recovery_codes(user_id, code_hash, key_id) values ($1, $2, $3)",
+			userID, utils.HashTokenHMAC(utils.NormalizeRecoveryCode(code)), utils.CurrentKeyID(),
+		)
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+func (api *API) enrollTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middlewares.UserIDKey).(int64)
+	if !ok || userID == 0 {
+		utils.WriteJSONError(w, http.StatusUnauthorized, "not_authorized", "you are not authorized")
+		return
+	}
+
+	var (
+		login   string
+		enabled bool
+	)
+	err := api.Pool.QueryRow(
+		r.Context(),
+		"select login, totp_enabled from users where id = $1",
+		userID,
+	).Scan(&login, &enabled)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to fetch user info")
+		return
+	}
+	if enabled {
+		utils.WriteJSONError(w, http.StatusConflict, "two_factor_already_enabled", "two-factor authentication is already enabled")
+		return
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "token_generation_failed", "failed to create secret")
+		return
+	}
+
+	sealed, err := utils.EncryptSecret(secret)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "token_generation_failed", "failed to create secret")
+		return
+	}
+
+	_, err = api.Pool.Exec(
+		r.Context(),
+		"update users set totp_secret = $1, totp_last_step = 0 where id = $2",
+		sealed, userID,
+	)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to save secret")
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, models.TwoFactorEnrollResponse{
+		Secret:     secret,
+		OTPAuthURI: utils.TOTPURI(api.Config.TOTPIssuer, login, secret),
+	})
+}
+
+func (api *API) confirmTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middlewares.UserIDKey).(int64)
+	if !ok || userID == 0 {
+		utils.WriteJSONError(w, http.StatusUnauthorized, "not_authorized", "you are not authorized")
+		return
+	}
+
+	var req models.TwoFactorCodeRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if strings.TrimSpace(req.Code) == "" {
+		utils.WriteJSONValidationError(w, "code", "code is required")
+		return
+	}
+
+	tx, err := api.Pool.Begin(r.Context())
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to start transaction")
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	var (
+		login    string
+		enabled  bool
+		enrolled bool
+	)
+	err = tx.QueryRow(
+		r.Context(),
+		"select login, totp_enabled, totp_secret is not null from users where id = $1",
+		userID,
+	).Scan(&login, &enabled, &enrolled)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to fetch user info")
+		return
+	}
+	if enabled {
+		utils.WriteJSONError(w, http.StatusConflict, "two_factor_already_enabled", "two-factor authentication is already enabled")
+		return
+	}
+	if !enrolled {
+		utils.WriteJSONError(w, http.StatusBadRequest, "two_factor_not_enrolled", "call /auth/2fa/enroll first")
+		return
+	}
+
+	if !api.checkSecondFactor(w, r, tx, userID, login, req.Code, "") {
+		return
+	}
+
+	_, err = tx.Exec(r.Context(), "update users set totp_enabled = true where id = $1", userID)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to enable two-factor authentication")
+		return
+	}
+
+	codes, err := api.replaceRecoveryCodes(r.Context(), tx, userID)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to create recovery codes")
+		return
+	}
+
+	err = tx.Commit(r.Context())
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to commit transaction")
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, models.RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+func (api *API) disableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middlewares.UserIDKey).(int64)
+	if !ok || userID == 0 {
+		utils.WriteJSONError(w, http.StatusUnauthorized, "not_authorized", "you are not authorized")
+		return
+	}
+
+	var req models.TwoFactorCodeRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if strings.TrimSpace(req.Code) == "" && strings.TrimSpace(req.RecoveryCode) == "" {
+		utils.WriteJSONValidationError(w, "code", "code or recovery_code is required")
+		return
+	}
+
+	tx, err := api.Pool.Begin(r.Context())
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to start transaction")
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	var (
+		login               string
+		enabled, privileged bool
+	)
+	err = tx.QueryRow(
+		r.Context(),
+		"select login, totp_enabled, "+privilegedColumn+" from users where id = $1",
+		userID,
+	).Scan(&login, &enabled, &privileged)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to fetch user info")
+		return
+	}
+	if !enabled {
+		utils.WriteJSONError(w, http.StatusBadRequest, "two_factor_not_enabled", "two-factor authentication is not enabled")
+		return
+	}
+	if privileged && api.Config.Require2FAForAdmins {
+		utils.WriteJSONError(w, http.StatusForbidden, "two_factor_required", "users with privileged roles are not allowed to disable two-factor authentication")
+		return
+	}
+
+	if !api.checkSecondFactor(w, r, tx, userID, login, req.Code, req.RecoveryCode) {
+		return
+	}
+
+	_, err = tx.Exec(
+		r.Context(),
+		"update users set totp_enabled = false, totp_secret = null, totp_last_step = 0 where id = $1",
+		userID,
+	)
+	if err == nil {
+		_, err = tx.Exec(r.Context(), "delete from recovery_codes where user_id = $1", userID)
+	}
+	if err == nil {
+		err = tx.Commit(r.Context())
+	}
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to disable two-factor authentication")
+		return
+	}
+
+	utils.WriteJSONSuccess(w, http.StatusOK)
+}
+
+func (api *API) regenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middlewares.UserIDKey).(int64)
+	if !ok || userID == 0 {
+		utils.WriteJSONError(w, http.StatusUnauthorized, "not_authorized", "you are not authorized")
+		return
+	}
+
+	var req models.TwoFactorCodeRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if strings.TrimSpace(req.Code) == "" {
+		utils.WriteJSONValidationError(w, "code", "code is required")
+		return
+	}
+
+	tx, err := api.Pool.Begin(r.Context())
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to start transaction")
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	var (
+		login   string
+		enabled bool
+	)
+	err = tx.QueryRow(r.Context(), "select login, totp_enabled from users where id = $1", userID).Scan(&login, &enabled)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to fetch user info")
+		return
+	}
+	if !enabled {
+		utils.WriteJSONError(w, http.StatusBadRequest, "two_factor_not_enabled", "two-factor authentication is not enabled")
+		return
+	}
+
+	if !api.checkSecondFactor(w, r, tx, userID, login, req.Code, "") {
+		return
+	}
+
+	codes, err := api.replaceRecoveryCodes(r.Context(), tx, userID)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to create recovery codes")
+		return
+	}
+
+	err = tx.Commit(r.Context())
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to commit transaction")
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, models.RecoveryCodesResponse{RecoveryCodes: codes})
+}
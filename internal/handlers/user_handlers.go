@@ -2,30 +2,110 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"rest-api/config"
 	"rest-api/internal/middlewares"
 	"rest-api/internal/models"
+	"rest-api/internal/rbac"
 	"rest-api/utils"
 	"strconv"
+	"strings"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
 )
 
 func (api *API) RegisterUserMethods(r chi.Router) {
 	r.Group(func(gr chi.Router) {
-		gr.Use(middlewares.AuthCheck(api.Pool))
-		gr.Use(middlewares.AddUserStatus(api.Pool))
-		gr.Get("/users", api.getUsers)
-		gr.Get("/users/{id}", api.getUser)
+		gr.Use(middlewares.AuthCheck(api.Pool, api.Config))
+		gr.Use(middlewares.AddUserStatus(api.Pool, api.Config))
+		gr.With(api.require(rbac.UsersRead)).Get("/users", api.getUsers)
+		gr.With(api.require(rbac.UsersRead)).Get("/users/{id}", api.getUser)
+		gr.With(api.require(rbac.UsersManage)).Post("/users/{id}/unlock", api.unlockUser)
+		gr.With(api.require(rbac.UsersManage)).Post("/users/{id}/2fa/reset", api.resetTwoFactor)
+		gr.Post("/users", api.createUser)
 	})
-	r.Post("/users", api.createUser)
 }
 
+const (
+	defaultUserPageSize = 50
+	maxUserPageSize     = 200
+)
+
+// getUsers pages through users ordered by login, which is unique and so
+// serves as the cursor on its own.
 func (api *API) getUsers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	conditions := []string{"true"}
+	args := []any{}
+	arg := func(value any) string {
+		args = append(args, value)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	if q := strings.TrimSpace(query.Get("q")); q != "" {
+		p := arg(escapeLike(strings.ToLower(q)) + "%")
+		conditions = append(conditions, fmt.Sprintf(
+			"(lower(users.login) like %[1]s or lower(users.family) like %[1]s or lower(users.name) like %[1]s or lower(users.surname) like %[1]s)", p,
+		))
+	}
+	if role := query.Get("role"); role != "" {
+		conditions = append(conditions, "exists(select 1 from user_roles ur join roles ro on ro.id = ur.role_id where ur.user_id = users.id and ro.name = "+arg(role)+")")
+	}
+	if value := query.Get("admin"); value != "" {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			utils.WriteJSONValidationError(w, "admin", "admin must be true or false")
+			return
+		}
+		conditions = append(conditions, "("+isAdminColumn+") = "+arg(b))
+	}
+
+	includeTotal := false
+	if value := query.Get("include_total"); value != "" {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			utils.WriteJSONValidationError(w, "include_total", "include_total must be true or false")
+			return
+		}
+		includeTotal = b
+	}
+
+	limit, err := parseLimitParam(query, defaultUserPageSize, maxUserPageSize)
+	if err != nil {
+		writeValidationError(w, err)
+		return
+	}
+
+	response := models.UserListResponse{}
+	where := strings.Join(conditions, " and ")
+	if includeTotal {
+		var total int
+		err = api.Pool.QueryRow(r.Context(), "select count(*) from users where "+where, args...).Scan(&total)
+		if err != nil {
+			utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to count users")
+			return
+		}
+		response.Total = &total
+	}
+
+	if value := query.Get("cursor"); value != "" {
+		after, err := decodeCursor(value)
+		if err != nil || after.Sort != "login" {
+			utils.WriteJSONValidationError(w, "cursor", "cursor is invalid")
+			return
+		}
+		where += " and users.login > " + arg(after.Value)
+	}
+
 	rows, err := api.Pool.Query(
 		r.Context(),
-		"select id, family, name, surname from users",
+		"select users.id, users.login, users.family, users.name, users.surname from users where "+where+" order by users.login limit "+arg(limit+1),
+		args...,
 	)
 	if err != nil {
 		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to fetch users")
@@ -33,11 +113,12 @@ func (api *API) getUsers(w http.ResponseWriter, r *http.Request) {
 	}
 	defer rows.Close()
 
-	users := []models.UserPublicResponse{}
+	users := []models.UserListItem{}
 	for rows.Next() {
-		user := models.UserPublicResponse{}
+		user := models.UserListItem{}
 		err := rows.Scan(
 			&user.Id,
+			&user.Login,
 			&user.Family,
 			&user.Name,
 			&user.Surname,
@@ -46,10 +127,20 @@ func (api *API) getUsers(w http.ResponseWriter, r *http.Request) {
 			utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to scan user row")
 			return
 		}
+		if len(users) == limit {
+			next := listCursor{Sort: "login", Value: users[limit-1].Login}.encode()
+			response.NextCursor = &next
+			break
+		}
 		users = append(users, user)
 	}
+	if rows.Err() != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to fetch users")
+		return
+	}
 
-	utils.WriteJSON(w, http.StatusOK, users)
+	response.Items = users
+	utils.WriteJSON(w, http.StatusOK, response)
 }
 
 func (api *API) createUser(w http.ResponseWriter, r *http.Request) {
@@ -66,6 +157,7 @@ func (api *API) createUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	user.Password = strings.TrimSpace(user.Password)
 	err = utils.ValidateUserRequest(user.Login, user.Family, user.Name, user.Surname, user.Password)
 	if err != nil {
 		valErr, ok := err.(*utils.ValidationError)
@@ -77,22 +169,95 @@ func (api *API) createUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Accounts created by someone with users.manage bypass the policy;
+	// everyone else needs it to be open or to bring an invitation.
+	user.InviteToken = strings.TrimSpace(user.InviteToken)
+	if !middlewares.HasPermission(r.Context(), rbac.UsersManage) {
+		switch {
+		case api.Config.RegistrationPolicy == config.RegistrationClosed:
+			utils.WriteJSONError(w, http.StatusForbidden, "registration_closed", "self-registration is disabled")
+			return
+		case api.Config.RegistrationPolicy == config.RegistrationInvite && user.InviteToken == "":
+			utils.WriteJSONError(w, http.StatusForbidden, "invitation_required", "an invitation is required to register")
+			return
+		}
+	}
+
 	hash, err := utils.HashPassword(user.Password)
 	if err != nil {
 		utils.WriteJSONError(w, http.StatusInternalServerError, "hash_error", "failed to hash password")
 		return
 	}
 
-	_, err = api.Pool.Exec(
+	tx, err := api.Pool.Begin(r.Context())
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to start transaction")
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	var (
+		invitationID int64
+		roleID       *int64
+	)
+	if user.InviteToken != "" {
+		err = tx.QueryRow(
+			r.Context(),
+			`select id, role_id from invitations
+			 where token_hash = any($1) and used_at is null and expires_at > now()
+			 for update`,
+			utils.TokenHashes(user.InviteToken),
+		).Scan(&invitationID, &roleID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			utils.WriteJSONValidationError(w, "invite_token", "invitation is invalid or expired")
+			return
+		}
+		if err != nil {
+			utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to check invitation")
+			return
+		}
+	}
+
+	var userID int64
+	err = tx.QueryRow(
 		r.Context(),
-		"insert into users (login, family, name, surname, password_hash, is_admin) values ($1, $2, $3, $4, $5, $6)",
+		"insert into users (login, family, name, surname, password_hash, is_admin) values ($1, $2, $3, $4, $5, $6) returning id",
 		user.Login, user.Family, user.Name, user.Surname, hash, false,
-	)
+	).Scan(&userID)
 
 	if err != nil {
 		utils.WriteJSONError(w, http.StatusConflict, "user_is_exist", "user with this login is already exist")
 		return
 	}
+
+	if invitationID != 0 {
+		_, err = tx.Exec(
+			r.Context(),
+			"update invitations set used_at = now(), used_by = $1 where id = $2",
+			userID, invitationID,
+		)
+		if err != nil {
+			utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to use invitation")
+			return
+		}
+	}
+
+	tag, err := tx.Exec(
+		r.Context(),
+		"insert into user_roles(user_id, role_id) select $1, id from roles where id = $2 or ($2 is null and name = $3)",
+		userID, roleID, api.Config.DefaultRole,
+	)
+	if err == nil && tag.RowsAffected() == 0 {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "role_missing", "the role for new users does not exist")
+		return
+	}
+	if err == nil {
+		err = tx.Commit(r.Context())
+	}
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to assign default role")
+		return
+	}
 	utils.WriteJSONSuccess(w, http.StatusCreated)
 }
 
@@ -126,3 +291,29 @@ func (api *API) getUser(w http.ResponseWriter, r *http.Request) {
 	}
 	utils.WriteJSON(w, http.StatusOK, user)
 }
+
+func (api *API) unlockUser(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || id <= 0 {
+		utils.WriteJSONError(w, http.StatusBadRequest, "invalid_id", "user id must be a positive integer")
+		return
+	}
+
+	var login string
+	err = api.Pool.QueryRow(
+		r.Context(),
+		"select login from users where id = $1",
+		id,
+	).Scan(&login)
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusNotFound, "not_found", "user with this id does not exist")
+		return
+	}
+
+	err = api.clearLoginFailures(r.Context(), loginAttemptKey(login))
+	if err != nil {
+		utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to unlock user")
+		return
+	}
+	utils.WriteJSONSuccess(w, http.StatusOK)
+}
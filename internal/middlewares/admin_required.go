@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"rest-api/config"
+	"rest-api/internal/rbac"
 	"rest-api/utils"
 
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -11,7 +13,7 @@ import (
 
 const IsAdminKey contextKey = "isAdmin"
 
-func UserStatusCheck(pool *pgxpool.Pool) func(http.Handler) http.Handler {
+func UserStatusCheck(pool *pgxpool.Pool, cfg *config.Config) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			userID, ok := r.Context().Value(UserIDKey).(int64)
@@ -20,44 +22,35 @@ func UserStatusCheck(pool *pgxpool.Pool) func(http.Handler) http.Handler {
 				return
 			}
 
-			var isAdmin bool
-			err := pool.QueryRow(
-				r.Context(),
-				"select is_admin from users where id = $1",
-				userID,
-			).Scan(&isAdmin)
-
+			ctx, access, err := withAccess(r.Context(), pool, cfg, userID)
 			if err != nil {
 				fmt.Println("database : ", err)
 				utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to fetch user info")
 				return
 			}
 
-			if !isAdmin {
+			if access.needs2FA {
+				utils.WriteJSONError(w, http.StatusForbidden, "two_factor_required", "privileged roles require two-factor authentication to access this resource")
+				return
+			}
+
+			if !access.isAdmin {
 				utils.WriteJSONError(w, http.StatusForbidden, "forbidden", "you must be an admin to access this resource")
 				return
 			}
 
-			ctx := context.WithValue(r.Context(), IsAdminKey, isAdmin)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
 
-func AddUserStatus(pool *pgxpool.Pool) func(http.Handler) http.Handler {
+func AddUserStatus(pool *pgxpool.Pool, cfg *config.Config) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			userID, ok := r.Context().Value(UserIDKey).(int64)
 			if ok && userID != 0 {
-				var isAdmin bool
-				err := pool.QueryRow(
-					r.Context(),
-					"select is_admin from users where id = $1",
-					userID,
-				).Scan(&isAdmin)
-
+				ctx, _, err := withAccess(r.Context(), pool, cfg, userID)
 				if err == nil {
-					ctx := context.WithValue(r.Context(), IsAdminKey, isAdmin)
 					next.ServeHTTP(w, r.WithContext(ctx))
 					return
 				}
@@ -67,3 +60,38 @@ func AddUserStatus(pool *pgxpool.Pool) func(http.Handler) http.Handler {
 		})
 	}
 }
+
+func RequirePermission(pool *pgxpool.Pool, cfg *config.Config, permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userID, ok := r.Context().Value(UserIDKey).(int64)
+			if !ok || userID == 0 {
+				utils.WriteJSONError(w, http.StatusUnauthorized, "not_authorized", "you are not authorized")
+				return
+			}
+
+			ctx, access, err := withAccess(r.Context(), pool, cfg, userID)
+			if err != nil {
+				fmt.Println("database : ", err)
+				utils.WriteJSONError(w, http.StatusInternalServerError, "db_error", "failed to fetch user info")
+				return
+			}
+
+			if !access.permissions.Has(permission) {
+				if access.needs2FA {
+					utils.WriteJSONError(w, http.StatusForbidden, "two_factor_required", "privileged roles require two-factor authentication to access this resource")
+					return
+				}
+				utils.WriteJSONError(w, http.StatusForbidden, "forbidden", "missing permission "+permission)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func HasPermission(ctx context.Context, permission string) bool {
+	permissions, _ := ctx.Value(PermissionsKey).(rbac.Set)
+	return permissions.Has(permission)
+}
@@ -3,8 +3,11 @@ package middlewares
 import (
 	"context"
 	"net/http"
-	"strings"
+	"rest-api/config"
+	"rest-api/internal/rbac"
 	"rest-api/utils"
+	"strings"
+	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 )
@@ -13,13 +16,37 @@ type contextKey string
 
 const UserIDKey contextKey = "userID"
 
-func AuthCheck(pool *pgxpool.Pool) func(http.Handler) http.Handler {
+const SessionIDKey contextKey = "sessionID"
+
+const AuthMethodKey contextKey = "authMethod"
+
+const APIKeyIDKey contextKey = "apiKeyID"
+
+const APIKeyScopesKey contextKey = "apiKeyScopes"
+
+const (
+	AuthMethodCookie = "cookie"
+	AuthMethodBearer = "bearer"
+	AuthMethodAPIKey = "api_key"
+)
+
+// touchInterval limits how often sessions and API keys write their
+// last-used timestamp.
+const touchInterval = time.Minute
+
+func AuthCheck(pool *pgxpool.Pool, cfg *config.Config) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			var token string
+			var (
+				token      string
+				fromCookie bool
+			)
 
 			authHeader := r.Header.Get("Authorization")
-			if authHeader != "" && strings.HasPrefix(authHeader, "Bearer ") {
+			apiKeyHeader := r.Header.Get("X-API-Key")
+			if apiKeyHeader != "" {
+				token = apiKeyHeader
+			} else if authHeader != "" && strings.HasPrefix(authHeader, "Bearer ") {
 				token = strings.TrimPrefix(authHeader, "Bearer ")
 			} else {
 				cookie, err := r.Cookie("session_token")
@@ -28,6 +55,7 @@ func AuthCheck(pool *pgxpool.Pool) func(http.Handler) http.Handler {
 					return
 				}
 				token = cookie.Value
+				fromCookie = true
 			}
 
 			if token == "" {
@@ -35,20 +63,135 @@ func AuthCheck(pool *pgxpool.Pool) func(http.Handler) http.Handler {
 				return
 			}
 
-			var user int64
+			if utils.IsAPIKey(token) {
+				ctx, ok := authenticateAPIKey(r.Context(), pool, token)
+				if ok {
+					r = r.WithContext(ctx)
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			var (
+				sessionID  int64
+				user       int64
+				expiresAt  time.Time
+				lastUsedAt time.Time
+				keyID      string
+				hasRefresh bool
+				csrfHash   *string
+				actorID    *int64
+			)
 			err := pool.QueryRow(
 				r.Context(),
-				"select user_id from sessions where token_hash = $1",
-				utils.HashTokenHMAC(token),
-			).Scan(&user)
+				`select id, user_id, expires_at, last_used_at, key_id, refresh_token_hash is not null, csrf_token_hash, impersonator_id
+				 from sessions where token_hash = any($1)`,
+				utils.TokenHashes(token),
+			).Scan(&sessionID, &user, &expiresAt, &lastUsedAt, &keyID, &hasRefresh, &csrfHash, &actorID)
 
 			if err != nil {
 				next.ServeHTTP(w, r)
 				return
 			}
 
+			now := time.Now()
+			if !now.Before(cfg.SessionDeadline(expiresAt, lastUsedAt)) {
+				pool.Exec(
+					r.Context(),
+					"delete from sessions where id = $1 and (refresh_expires_at is null or refresh_expires_at <= now())",
+					sessionID,
+				)
+				if fromCookie {
+					utils.ClearSessionCookie(w)
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if fromCookie && !ValidCSRF(r, csrfHash) {
+				utils.WriteJSONError(w, http.StatusForbidden, "csrf_failed", "missing or invalid "+CSRFHeader+" header")
+				return
+			}
+
+			// last_used_at always follows activity and moves the server side
+			// idle deadline. Only sliding sessions re-issue the cookie; the
+			// others got the absolute expiry at login, see
+			// SessionCookieExpiry.
+			if now.Sub(lastUsedAt) >= touchInterval {
+				_, err = pool.Exec(r.Context(), "update sessions set last_used_at = $1 where id = $2", now, sessionID)
+				if err == nil && fromCookie && cfg.SessionSliding {
+					utils.SetSessionCookie(w, token, cfg.SessionCookieExpiry(expiresAt, now))
+				}
+			}
+
+			// Tokens hashed with a previous key are moved to the current one
+			// on first use. Refresh sessions are skipped: their refresh hash
+			// shares key_id and will be replaced on the next rotation anyway.
+			if keyID != utils.CurrentKeyID() && !hasRefresh {
+				pool.Exec(
+					r.Context(),
+					"update sessions set token_hash = $1, key_id = $2 where id = $3",
+					utils.HashTokenHMAC(token), utils.CurrentKeyID(), sessionID,
+				)
+			}
+
+			method := AuthMethodBearer
+			if fromCookie {
+				method = AuthMethodCookie
+			}
+
 			ctx := context.WithValue(r.Context(), UserIDKey, user)
+			ctx = context.WithValue(ctx, SessionIDKey, sessionID)
+			ctx = context.WithValue(ctx, AuthMethodKey, method)
+			if actorID != nil {
+				ctx = context.WithValue(ctx, ImpersonatorIDKey, *actorID)
+				serveImpersonated(pool, next, w, r.WithContext(ctx), sessionID, *actorID, user)
+				return
+			}
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
+
+func authenticateAPIKey(ctx context.Context, pool *pgxpool.Pool, key string) (context.Context, bool) {
+	var (
+		keyID      int64
+		user       int64
+		scopes     []string
+		lastUsedAt *time.Time
+		hmacKeyID  string
+	)
+	err := pool.QueryRow(
+		ctx,
+		`select id, user_id, scopes, last_used_at, key_id from api_keys
+		 where key_hash = any($1) and revoked_at is null and (expires_at is null or expires_at > now())`,
+		utils.TokenHashes(key),
+	).Scan(&keyID, &user, &scopes, &lastUsedAt, &hmacKeyID)
+	if err != nil {
+		return ctx, false
+	}
+
+	if hmacKeyID != utils.CurrentKeyID() {
+		pool.Exec(
+			ctx,
+			"update api_keys set key_hash = $1, key_id = $2 where id = $3",
+			utils.HashTokenHMAC(key), utils.CurrentKeyID(), keyID,
+		)
+	}
+
+	now := time.Now()
+	if lastUsedAt == nil || now.Sub(*lastUsedAt) >= touchInterval {
+		pool.Exec(ctx, "update api_keys set last_used_at = $1 where id = $2", now, keyID)
+	}
+
+	scopeSet := rbac.Set{}
+	for _, scope := range scopes {
+		scopeSet[scope] = true
+	}
+
+	ctx = context.WithValue(ctx, UserIDKey, user)
+	ctx = context.WithValue(ctx, AuthMethodKey, AuthMethodAPIKey)
+	ctx = context.WithValue(ctx, APIKeyIDKey, keyID)
+	ctx = context.WithValue(ctx, APIKeyScopesKey, scopeSet)
+	return ctx, true
+}
@@ -0,0 +1,30 @@
+package middlewares
+
+import (
+	"net/http"
+	"rest-api/utils"
+)
+
+const CSRFHeader = "X-CSRF-Token"
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// ValidCSRF checks the synchronizer token sent by the browser against the
+// hash stored with the session. Only cookie-authenticated requests need it:
+// a Bearer token or API key is never attached by the browser on its own.
+func ValidCSRF(r *http.Request, csrfHash *string) bool {
+	if isSafeMethod(r.Method) {
+		return true
+	}
+	token := r.Header.Get(CSRFHeader)
+	if token == "" || csrfHash == nil {
+		return false
+	}
+	return utils.CompareTokenHash(token, *csrfHash)
+}
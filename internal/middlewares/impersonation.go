@@ -0,0 +1,77 @@
+package middlewares
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"rest-api/internal/audit"
+	"rest-api/utils"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ImpersonatorIDKey holds the real actor when an admin is acting as another
+// user. UserIDKey always holds the impersonated user.
+const ImpersonatorIDKey contextKey = "impersonatorID"
+
+// NoImpersonation rejects requests made from an impersonation session, for
+// endpoints that manage the account itself or grant further privileges.
+func NoImpersonation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Value(ImpersonatorIDKey).(int64); ok {
+			utils.WriteJSONError(w, http.StatusForbidden, "impersonation_forbidden", "this endpoint cannot be used while impersonating a user")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// serveImpersonated runs the request and writes it to the audit log once the
+// response status is known.
+func serveImpersonated(pool *pgxpool.Pool, next http.Handler, w http.ResponseWriter, r *http.Request, sessionID, actorID, userID int64) {
+	rec := &statusRecorder{ResponseWriter: w}
+	next.ServeHTTP(rec, r)
+
+	status := rec.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	err := audit.Record(context.WithoutCancel(r.Context()), pool, audit.Entry{
+		ActorID: actorID,
+		UserID:  userID,
+		Action:  audit.ActionImpersonationRequest,
+		Details: map[string]any{
+			"session_id": sessionID,
+			"method":     r.Method,
+			"path":       r.URL.Path,
+			"status":     status,
+		},
+		IP: utils.ClientIP(r),
+	})
+	if err != nil {
+		fmt.Println("audit : ", err)
+	}
+}
@@ -0,0 +1,115 @@
+package middlewares
+
+import (
+	"context"
+	"rest-api/config"
+	"rest-api/internal/rbac"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const PermissionsKey contextKey = "permissions"
+
+const accessKey contextKey = "access"
+
+type access struct {
+	isAdmin     bool
+	needs2FA    bool
+	permissions rbac.Set
+}
+
+// withAccess loads the user's roles once per request and stores the result
+// in the context. Requests made with an API key only get what the key's
+// scopes allow. Impersonation sessions never get privileged permissions, nor
+// anything the impersonating user does not hold themselves.
+func withAccess(ctx context.Context, pool *pgxpool.Pool, cfg *config.Config, userID int64) (context.Context, *access, error) {
+	if loaded, ok := ctx.Value(accessKey).(*access); ok {
+		return ctx, loaded, nil
+	}
+
+	a, err := loadAccess(ctx, pool, cfg, userID)
+	if err != nil {
+		return ctx, nil, err
+	}
+
+	if scopes, ok := ctx.Value(APIKeyScopesKey).(rbac.Set); ok {
+		a.permissions = a.permissions.Restrict(scopes)
+		a.isAdmin = a.isAdmin && scopes[rbac.Wildcard]
+	}
+
+	if actorID, ok := ctx.Value(ImpersonatorIDKey).(int64); ok {
+		actor, err := loadAccess(ctx, pool, cfg, actorID)
+		if err != nil {
+			return ctx, nil, err
+		}
+		a.permissions = a.permissions.Restrict(actor.permissions).Without(rbac.Privileged...)
+		a.isAdmin = false
+	}
+
+	ctx = context.WithValue(ctx, accessKey, a)
+	ctx = context.WithValue(ctx, IsAdminKey, a.isAdmin)
+	ctx = context.WithValue(ctx, PermissionsKey, a.permissions)
+	return ctx, a, nil
+}
+
+// loadAccess reads what the user's roles grant. Roles granting the wildcard
+// or a privileged permission are withheld while the 2FA policy is not met.
+func loadAccess(ctx context.Context, pool *pgxpool.Pool, cfg *config.Config, userID int64) (*access, error) {
+	var totpEnabled bool
+	err := pool.QueryRow(ctx, "select totp_enabled from users where id = $1", userID).Scan(&totpEnabled)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := pool.Query(
+		ctx,
+		`select ro.name, rp.permission
+		 from user_roles ur
+		 join roles ro on ro.id = ur.role_id
+		 left join role_permissions rp on rp.role_id = ro.id
+		 where ur.user_id = $1`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type grant struct {
+		role       string
+		permission *string
+	}
+	var grants []grant
+	hasAdminRole := false
+	privilegedRoles := map[string]bool{}
+	for rows.Next() {
+		var g grant
+		err := rows.Scan(&g.role, &g.permission)
+		if err != nil {
+			return nil, err
+		}
+		if g.role == rbac.AdminRole {
+			hasAdminRole = true
+		}
+		if g.permission != nil && rbac.IsPrivileged(*g.permission) {
+			privilegedRoles[g.role] = true
+		}
+		grants = append(grants, g)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	a := &access{permissions: rbac.Set{}}
+	a.needs2FA = len(privilegedRoles) > 0 && cfg.Require2FAForAdmins && !totpEnabled
+	a.isAdmin = hasAdminRole && !a.needs2FA
+
+	for _, g := range grants {
+		if g.permission == nil || (a.needs2FA && privilegedRoles[g.role]) {
+			continue
+		}
+		a.permissions[*g.permission] = true
+	}
+
+	return a, nil
+}
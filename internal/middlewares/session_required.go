@@ -0,0 +1,19 @@
+package middlewares
+
+import (
+	"net/http"
+	"rest-api/utils"
+)
+
+// RequireSession rejects requests that were authenticated with an API key, so
+// a leaked key cannot be used to manage credentials of its owner.
+func RequireSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, _ := r.Context().Value(AuthMethodKey).(string)
+		if method == AuthMethodAPIKey {
+			utils.WriteJSONError(w, http.StatusForbidden, "session_required", "this endpoint cannot be used with an API key")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+type AttachmentResponse struct {
+	Id          int64     `json:"id"`
+	TaskId      int64     `json:"task_id"`
+	UploaderId  *int64    `json:"uploader_id"`
+	Filename    string    `json:"filename"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	Sha256      string    `json:"sha256"`
+	CreatedAt   time.Time `json:"created_at"`
+}
@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+type ImpersonationRequest struct {
+	Reason string `json:"reason"`
+}
+
+type ImpersonationResponse struct {
+	Status    string             `json:"status"`
+	Token     string             `json:"token"`
+	ExpiresAt time.Time          `json:"expires_at"`
+	User      UserPublicResponse `json:"user"`
+}
+
+type AuditLogEntry struct {
+	Id        int64          `json:"id"`
+	ActorId   *int64         `json:"actor_id"`
+	UserId    *int64         `json:"user_id"`
+	Action    string         `json:"action"`
+	Details   map[string]any `json:"details"`
+	IP        *string        `json:"ip"`
+	CreatedAt time.Time      `json:"created_at"`
+}
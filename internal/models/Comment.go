@@ -0,0 +1,38 @@
+package models
+
+import "time"
+
+type CommentRequest struct {
+	Body string `json:"body"`
+}
+
+type CommentResponse struct {
+	Id        int64               `json:"id"`
+	TaskId    int64               `json:"task_id"`
+	Author    *UserPublicResponse `json:"author"`
+	Body      string              `json:"body"`
+	CreatedAt time.Time           `json:"created_at"`
+	UpdatedAt *time.Time          `json:"updated_at"`
+}
+
+type CommentListResponse struct {
+	Items      []CommentResponse `json:"items"`
+	NextCursor *string           `json:"next_cursor"`
+}
+
+type CommentRevisionResponse struct {
+	Id        int64     `json:"id"`
+	Body      string    `json:"body"`
+	EditorId  *int64    `json:"editor_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type NotificationResponse struct {
+	Id        int64      `json:"id"`
+	Kind      string     `json:"kind"`
+	ActorId   *int64     `json:"actor_id"`
+	TaskId    *int64     `json:"task_id"`
+	CommentId *int64     `json:"comment_id"`
+	CreatedAt time.Time  `json:"created_at"`
+	ReadAt    *time.Time `json:"read_at"`
+}
@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+type InvitationRequest struct {
+	RoleId    *int64     `json:"role_id"`
+	Note      string     `json:"note"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+type InvitationResponse struct {
+	Id        int64      `json:"id"`
+	Role      string     `json:"role"`
+	Note      string     `json:"note"`
+	CreatedBy *int64     `json:"created_by"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	UsedBy    *int64     `json:"used_by"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+type InvitationCreatedResponse struct {
+	InvitationResponse
+	Token string `json:"token"`
+}
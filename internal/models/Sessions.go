@@ -3,9 +3,43 @@ package models
 import "time"
 
 type Session struct {
-	Id        int64
-	UserId    int64
-	Token     string
-	CreatedAt time.Time
-	ExpiresAt time.Time
+	Id               int64
+	UserId           int64
+	Token            string
+	CreatedAt        time.Time
+	ExpiresAt        time.Time
+	LastUsedAt       time.Time
+	RefreshToken     string
+	RefreshExpiresAt time.Time
+	FamilyId         string
+	IP               string
+	UserAgent        string
+	CSRFToken        string
+}
+
+type SessionResponse struct {
+	Id         int64     `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+	Current    bool      `json:"current"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type TokenPairResponse struct {
+	Status           string    `json:"status"`
+	Token            string    `json:"token"`
+	ExpiresAt        time.Time `json:"expires_at"`
+	RefreshToken     string    `json:"refresh_token"`
+	RefreshExpiresAt time.Time `json:"refresh_expires_at"`
+	CSRFToken        string    `json:"csrf_token"`
+}
+
+type CSRFTokenResponse struct {
+	CSRFToken string `json:"csrf_token"`
 }
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 )
 
@@ -10,10 +11,99 @@ type Task struct {
 	Description string
 	CreatedAt   time.Time
 	IsCompleted bool
+	Status      string
+	Priority    string
+	StartAt     *time.Time
+	DueAt       *time.Time
+
+	CompletionPolicy string
+	OwnerId          *int64
+	MyCompletedAt    *time.Time
+	AssigneeCount    int
+	CompletedCount   int
+
+	ParentId              *int64
+	SubtaskCount          int
+	CompletedSubtaskCount int
+
+	// OpenBlockers is only set on the response of a change that completed
+	// the task although it was still blocked, under the warn rule.
+	OpenBlockers []int64 `json:",omitempty"`
 }
 
 type TaskRequest struct {
-	Title        string `json:"title"`
-	Description  string `json:"description"`
-	Is_completed bool   `json:"is_completed"`
+	Title        string     `json:"title"`
+	Description  string     `json:"description"`
+	Is_completed bool       `json:"is_completed"`
+	Priority     string     `json:"priority"`
+	StartAt      *time.Time `json:"start_at"`
+	DueAt        *time.Time `json:"due_at"`
+
+	CompletionPolicy string `json:"completion_policy"`
+	OwnerId          *int64 `json:"owner_id"`
+	ParentId         *int64 `json:"parent_id"`
+}
+
+type TaskPatchRequest struct {
+	Title       *string             `json:"title"`
+	Description *string             `json:"description"`
+	IsCompleted *bool               `json:"is_completed"`
+	Priority    *string             `json:"priority"`
+	StartAt     Nullable[time.Time] `json:"start_at"`
+	DueAt       Nullable[time.Time] `json:"due_at"`
+
+	CompletionPolicy *string         `json:"completion_policy"`
+	OwnerId          Nullable[int64] `json:"owner_id"`
+	ParentId         Nullable[int64] `json:"parent_id"`
+}
+
+// Nullable tells an explicit null (clear the value) apart from a field that
+// was left out of a partial update.
+type Nullable[T any] struct {
+	Set   bool
+	Value *T
+}
+
+func (n *Nullable[T]) UnmarshalJSON(data []byte) error {
+	n.Set = true
+	return json.Unmarshal(data, &n.Value)
+}
+
+type TaskUsersRequest struct {
+	UserIds []int64 `json:"user_ids"`
+}
+
+type TaskListResponse struct {
+	Items      []Task  `json:"items"`
+	NextCursor *string `json:"next_cursor"`
+}
+
+// TaskTreeNode is a task with the subtasks visible to the caller. Progress
+// is rolled up over all subtasks: 1 for a completed task, otherwise the mean
+// progress of its children, and 0 for an open task without children.
+type TaskTreeNode struct {
+	Task     Task           `json:"task"`
+	Progress float64        `json:"progress"`
+	Children []TaskTreeNode `json:"children"`
+}
+
+type TaskStatus struct {
+	Name     string `json:"name"`
+	Position int    `json:"position"`
+	Terminal bool   `json:"terminal"`
+}
+
+type TaskTransition struct {
+	From       string `json:"from"`
+	To         string `json:"to"`
+	Permission string `json:"permission"`
+}
+
+type TaskWorkflow struct {
+	Statuses    []TaskStatus     `json:"statuses"`
+	Transitions []TaskTransition `json:"transitions"`
+}
+
+type TaskTransitionRequest struct {
+	To string `json:"to"`
 }
@@ -0,0 +1,41 @@
+package models
+
+import "time"
+
+type TaskDependencyRequest struct {
+	BlockerId int64 `json:"blocker_id"`
+}
+
+// TaskGraphNode describes a task in a dependency listing or graph. Tasks the
+// caller cannot see are reduced to their id and completion state.
+type TaskGraphNode struct {
+	Id          int64      `json:"id"`
+	Title       string     `json:"title,omitempty"`
+	Status      string     `json:"status,omitempty"`
+	IsCompleted bool       `json:"is_completed"`
+	DueAt       *time.Time `json:"due_at,omitempty"`
+	Hidden      bool       `json:"hidden"`
+}
+
+type TaskDependenciesResponse struct {
+	BlockedBy []TaskGraphNode `json:"blocked_by"`
+	Blocks    []TaskGraphNode `json:"blocks"`
+}
+
+// TaskGraphEdge means From blocks To.
+type TaskGraphEdge struct {
+	From int64 `json:"from"`
+	To   int64 `json:"to"`
+}
+
+type TaskGraph struct {
+	Nodes []TaskGraphNode `json:"nodes"`
+	Edges []TaskGraphEdge `json:"edges"`
+	// CriticalPath is the longest chain of open tasks, first blocker first.
+	CriticalPath []int64 `json:"critical_path"`
+}
+
+type TaskCompletionResponse struct {
+	Status       string  `json:"status"`
+	OpenBlockers []int64 `json:"open_blockers,omitempty"`
+}
@@ -33,23 +33,43 @@ type UserPublicResponse struct {
 }
 
 type UserProfileResponse struct {
-	Id      int    `json:"id"`
-	Family  string `json:"family"`
-	Name    string `json:"name"`
-	Surname string `json:"surname"`
-	IsAdmin bool   `json:"is_admin"`
+	Id             int                 `json:"id"`
+	Family         string              `json:"family"`
+	Name           string              `json:"name"`
+	Surname        string              `json:"surname"`
+	IsAdmin        bool                `json:"is_admin"`
+	ImpersonatedBy *UserPublicResponse `json:"impersonated_by,omitempty"`
 }
 
 type LoginResponse struct {
-	Status string              `json:"status"`
-	Token  string              `json:"token"`
-	User   UserProfileResponse `json:"user"`
+	Status           string              `json:"status"`
+	Token            string              `json:"token"`
+	ExpiresAt        time.Time           `json:"expires_at"`
+	RefreshToken     string              `json:"refresh_token,omitempty"`
+	RefreshExpiresAt *time.Time          `json:"refresh_expires_at,omitempty"`
+	CSRFToken        string              `json:"csrf_token"`
+	User             UserProfileResponse `json:"user"`
 }
 
 type UserRequest struct {
-	Login    string `json:"login"`
-	Family   string `json:"family"`
-	Name     string `json:"name"`
-	Surname  string `json:"surname"`
-	Password string `json:"password"`
+	Login       string `json:"login"`
+	Family      string `json:"family"`
+	Name        string `json:"name"`
+	Surname     string `json:"surname"`
+	Password    string `json:"password"`
+	InviteToken string `json:"invite_token"`
+}
+
+type UserListItem struct {
+	Id      int    `json:"id"`
+	Login   string `json:"login"`
+	Family  string `json:"family"`
+	Name    string `json:"name"`
+	Surname string `json:"surname"`
+}
+
+type UserListResponse struct {
+	Items      []UserListItem `json:"items"`
+	NextCursor *string        `json:"next_cursor"`
+	Total      *int           `json:"total,omitempty"`
 }
@@ -0,0 +1,30 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"rest-api/config"
+	"time"
+)
+
+type PasswordReset struct {
+	UserID    int64     `json:"user_id"`
+	Login     string    `json:"login"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type Notifier interface {
+	SendPasswordReset(ctx context.Context, msg PasswordReset) error
+}
+
+func New(cfg *config.Config) (Notifier, error) {
+	switch cfg.Notifier {
+	case "", "log":
+		return NewLogNotifier(cfg.NotifierLogTokens), nil
+	case "file":
+		return NewFileNotifier(cfg.NotifierFile), nil
+	default:
+		return nil, fmt.Errorf("unknown notifier %q", cfg.Notifier)
+	}
+}
@@ -0,0 +1,139 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type testIDP struct {
+	srv    *httptest.Server
+	key    *rsa.PrivateKey
+	claims map[string]any
+}
+
+func newTestIDP(t *testing.T) *testIDP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &testIDP{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.srv.URL,
+			"authorization_endpoint": idp.srv.URL + "/authorize",
+			"token_endpoint":         idp.srv.URL + "/token",
+			"jwks_uri":               idp.srv.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test",
+			"n":   base64.RawURLEncoding.EncodeToString(key.PublicKey.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.PublicKey.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"id_token":     idp.sign(t, idp.claims, idp.key),
+		})
+	})
+	idp.srv = httptest.NewServer(mux)
+	t.Cleanup(idp.srv.Close)
+	return idp
+}
+
+func (idp *testIDP) sign(t *testing.T, claims map[string]any, key *rsa.PrivateKey) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test"})
+	payload, _ := json.Marshal(claims)
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(input))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func (idp *testIDP) validClaims() map[string]any {
+	now := time.Now()
+	return map[string]any{
+		"iss":   idp.srv.URL,
+		"sub":   "alice",
+		"aud":   "rest-api",
+		"iat":   now.Unix(),
+		"exp":   now.Add(5 * time.Minute).Unix(),
+		"nonce": "nonce",
+	}
+}
+
+// TestCallbackTokenValidation runs the code exchange and ID token check the
+// callback performs against a provider that returns tampered tokens.
+func TestCallbackTokenValidation(t *testing.T) {
+	idp := newTestIDP(t)
+	provider := NewProvider(Config{Issuer: idp.srv.URL, ClientID: "rest-api"})
+
+	tests := []struct {
+		name   string
+		modify func(claims map[string]any)
+		nonce  string
+		valid  bool
+	}{
+		{name: "valid", modify: func(map[string]any) {}, nonce: "nonce", valid: true},
+		{name: "audience as array", modify: func(c map[string]any) { c["aud"] = []string{"other", "rest-api"} }, nonce: "nonce", valid: true},
+		{name: "bad nonce", modify: func(map[string]any) {}, nonce: "other"},
+		{name: "bad issuer", modify: func(c map[string]any) { c["iss"] = "https://evil.example" }, nonce: "nonce"},
+		{name: "bad audience", modify: func(c map[string]any) { c["aud"] = "other-client" }, nonce: "nonce"},
+		{name: "expired", modify: func(c map[string]any) { c["exp"] = time.Now().Add(-2 * clockSkew).Unix() }, nonce: "nonce"},
+		{name: "missing subject", modify: func(c map[string]any) { delete(c, "sub") }, nonce: "nonce"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idp.claims = idp.validClaims()
+			tt.modify(idp.claims)
+
+			token, err := provider.Exchange(context.Background(), "code", "verifier")
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = provider.VerifyIDToken(context.Background(), token.IDToken, tt.nonce)
+			if tt.valid && err != nil {
+				t.Fatalf("valid token rejected: %v", err)
+			}
+			if !tt.valid && !errors.Is(err, ErrInvalidIDToken) {
+				t.Fatalf("got %v, want ErrInvalidIDToken", err)
+			}
+		})
+	}
+}
+
+func TestVerifyIDTokenRejectsForeignSignature(t *testing.T) {
+	idp := newTestIDP(t)
+	provider := NewProvider(Config{Issuer: idp.srv.URL, ClientID: "rest-api"})
+
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := idp.sign(t, idp.validClaims(), other)
+	_, err = provider.VerifyIDToken(context.Background(), raw, "nonce")
+	if !errors.Is(err, ErrInvalidIDToken) {
+		t.Fatalf("got %v, want ErrInvalidIDToken", err)
+	}
+}
@@ -0,0 +1,125 @@
+package rbac
+
+const (
+	Wildcard = "*"
+
+	TasksRead     = "tasks.read"
+	TasksReadAll  = "tasks.read_all"
+	TasksCreate   = "tasks.create"
+	TasksAssign   = "tasks.assign"
+	TasksComplete = "tasks.complete"
+	TasksUpdate   = "tasks.update"
+	TasksDelete   = "tasks.delete"
+	TasksComment  = "tasks.comment"
+	TasksAttach   = "tasks.attach"
+
+	UsersRead        = "users.read"
+	UsersManage      = "users.manage"
+	UsersImpersonate = "users.impersonate"
+
+	RolesManage = "roles.manage"
+
+	AuditRead = "audit.read"
+
+	WorkflowManage = "workflow.manage"
+)
+
+const AdminRole = "admin"
+
+var AllPermissions = []string{
+	TasksRead,
+	TasksReadAll,
+	TasksCreate,
+	TasksAssign,
+	TasksComplete,
+	TasksUpdate,
+	TasksDelete,
+	TasksComment,
+	TasksAttach,
+	UsersRead,
+	UsersManage,
+	UsersImpersonate,
+	RolesManage,
+	AuditRead,
+	WorkflowManage,
+}
+
+// Privileged permissions are never available to an impersonation session,
+// whatever roles the impersonated user holds.
+var Privileged = []string{
+	UsersManage,
+	UsersImpersonate,
+	RolesManage,
+	AuditRead,
+	WorkflowManage,
+}
+
+// IsPrivileged reports whether permission is the wildcard or one of the
+// privileged permissions.
+func IsPrivileged(permission string) bool {
+	if permission == Wildcard {
+		return true
+	}
+	for _, p := range Privileged {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+func IsKnown(permission string) bool {
+	if permission == Wildcard {
+		return true
+	}
+	for _, p := range AllPermissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+type Set map[string]bool
+
+func (s Set) Has(permission string) bool {
+	return s[Wildcard] || s[permission]
+}
+
+func (s Set) List() []string {
+	list := make([]string, 0, len(s))
+	for p := range s {
+		list = append(list, p)
+	}
+	return list
+}
+
+// Restrict narrows the granted permissions down to the given scopes. A
+// wildcard scope keeps everything the owner has.
+func (s Set) Restrict(scopes Set) Set {
+	if scopes[Wildcard] {
+		return s
+	}
+	restricted := Set{}
+	for scope := range scopes {
+		if s.Has(scope) {
+			restricted[scope] = true
+		}
+	}
+	return restricted
+}
+
+// Without expands a wildcard into the known permissions and drops the given
+// ones.
+func (s Set) Without(permissions ...string) Set {
+	remaining := Set{}
+	for _, p := range AllPermissions {
+		if s.Has(p) {
+			remaining[p] = true
+		}
+	}
+	for _, p := range permissions {
+		delete(remaining, p)
+	}
+	return remaining
+}
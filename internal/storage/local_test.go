@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocalPath(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewLocal(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"tasks/1/a.txt", filepath.Join(dir, "tasks", "1", "a.txt")},
+		{"tasks/../a.txt", filepath.Join(dir, "a.txt")},
+		{"./a.txt", filepath.Join(dir, "a.txt")},
+		{"..a.txt", filepath.Join(dir, "..a.txt")},
+		{"", ""},
+		{"..", ""},
+		{"../a.txt", ""},
+		{"tasks/../../a.txt", ""},
+		{"/etc/passwd", ""},
+	}
+	for _, tt := range tests {
+		got, err := s.path(tt.key)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("path(%q) = %q, want an error", tt.key, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("path(%q) = %q, %v, want %q", tt.key, got, err, tt.want)
+		}
+	}
+}
+
+func TestLocalRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	s, err := NewLocal(filepath.Join(root, "blobs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.Put(ctx, "tasks/1/a.txt", strings.NewReader("hello"), 5, "text/plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, err := s.Open(ctx, "tasks/1/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(obj)
+	obj.Close()
+	if err != nil || string(data) != "hello" || obj.Size() != 5 {
+		t.Errorf("read %q (size %d), %v, want hello", data, obj.Size(), err)
+	}
+
+	err = s.Put(ctx, "../escaped.txt", strings.NewReader("x"), 1, "text/plain")
+	if err == nil {
+		t.Error("put outside the storage directory succeeded")
+	}
+	if _, statErr := os.Stat(filepath.Join(root, "escaped.txt")); statErr == nil {
+		t.Error("put wrote a file outside the storage directory")
+	}
+
+	err = s.Delete(ctx, "tasks/1/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Open(ctx, "tasks/1/a.txt")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("open after delete: got %v, want ErrNotFound", err)
+	}
+	err = s.Delete(ctx, "tasks/1/a.txt")
+	if err != nil {
+		t.Errorf("deleting a missing blob: %v", err)
+	}
+}
@@ -0,0 +1,8 @@
+alter table sessions add column if not exists created_at timestamptz not null default now();
+alter table sessions add column if not exists expires_at timestamptz;
+alter table sessions add column if not exists last_used_at timestamptz not null default now();
+
+update sessions set expires_at = created_at + interval '30 days' where expires_at is null;
+alter table sessions alter column expires_at set not null;
+
+create index if not exists sessions_expires_at_idx on sessions(expires_at);
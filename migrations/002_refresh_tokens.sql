@@ -0,0 +1,7 @@
+alter table sessions add column if not exists refresh_token_hash text;
+alter table sessions add column if not exists refresh_expires_at timestamptz;
+alter table sessions add column if not exists refresh_used_at timestamptz;
+alter table sessions add column if not exists family_id text;
+
+create unique index if not exists sessions_refresh_token_hash_idx on sessions(refresh_token_hash);
+create index if not exists sessions_family_id_idx on sessions(family_id);
@@ -0,0 +1,4 @@
+alter table sessions add column if not exists ip text;
+alter table sessions add column if not exists user_agent text;
+
+create index if not exists sessions_user_id_idx on sessions(user_id);
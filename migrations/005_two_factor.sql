@@ -0,0 +1,22 @@
+alter table users add column if not exists totp_secret text;
+alter table users add column if not exists totp_enabled boolean not null default false;
+alter table users add column if not exists totp_last_step bigint not null default 0;
+
+create table if not exists recovery_codes (
+	id         bigserial primary key,
+	user_id    bigint not null references users(id) on delete cascade,
+	code_hash  text not null,
+	used_at    timestamptz,
+	created_at timestamptz not null default now()
+);
+create index if not exists recovery_codes_user_id_idx on recovery_codes(user_id);
+
+create table if not exists two_factor_challenges (
+	id                  bigserial primary key,
+	user_id             bigint not null references users(id) on delete cascade,
+	token_hash          text not null unique,
+	expires_at          timestamptz not null,
+	attempts            integer not null default 0,
+	issue_refresh_token boolean not null default false,
+	created_at          timestamptz not null default now()
+);
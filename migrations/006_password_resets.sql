@@ -0,0 +1,9 @@
+create table if not exists password_resets (
+	id         bigserial primary key,
+	user_id    bigint not null references users(id) on delete cascade,
+	token_hash text not null unique,
+	expires_at timestamptz not null,
+	used_at    timestamptz,
+	created_at timestamptz not null default now()
+);
+create index if not exists password_resets_user_id_idx on password_resets(user_id);
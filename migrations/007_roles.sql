@@ -0,0 +1,50 @@
+create table if not exists roles (
+	id          bigserial primary key,
+	name        text not null unique,
+	description text not null default '',
+	builtin     boolean not null default false,
+	created_at  timestamptz not null default now()
+);
+
+create table if not exists role_permissions (
+	role_id    bigint not null references roles(id) on delete cascade,
+	permission text not null,
+	primary key (role_id, permission)
+);
+
+create table if not exists user_roles (
+	user_id bigint not null references users(id) on delete cascade,
+	role_id bigint not null references roles(id) on delete cascade,
+	primary key (user_id, role_id)
+);
+
+insert into roles(name, description, builtin) values
+	('admin', 'Full access to everything', true),
+	('manager', 'Creates and assigns tasks', true),
+	('member', 'Works on assigned tasks', true),
+	('viewer', 'Read-only access', true)
+on conflict (name) do nothing;
+
+insert into role_permissions(role_id, permission)
+select ro.id, p.permission
+from roles ro
+join (values
+	('admin', '*'),
+	('manager', 'tasks.read'),
+	('manager', 'tasks.read_all'),
+	('manager', 'tasks.create'),
+	('manager', 'tasks.assign'),
+	('manager', 'tasks.complete'),
+	('manager', 'users.read'),
+	('member', 'tasks.read'),
+	('member', 'tasks.complete'),
+	('member', 'users.read'),
+	('viewer', 'tasks.read'),
+	('viewer', 'users.read')
+) as p(role, permission) on p.role = ro.name
+on conflict do nothing;
+
+-- users.is_admin is kept for old clients but is no longer read by the API.
+insert into user_roles(user_id, role_id)
+select u.id, ro.id from users u join roles ro on ro.name = case when u.is_admin then 'admin' else 'member' end
+on conflict do nothing;
@@ -0,0 +1,9 @@
+-- Rows written before the keyring existed were hashed with SECRET_KEY, which
+-- gets the default SECRET_KEY_ID of '1'.
+alter table sessions add column if not exists key_id text not null default '1';
+alter table api_keys add column if not exists key_id text not null default '1';
+alter table two_factor_challenges add column if not exists key_id text not null default '1';
+alter table recovery_codes add column if not exists key_id text not null default '1';
+alter table password_resets add column if not exists key_id text not null default '1';
+
+create index if not exists sessions_key_id_idx on sessions(key_id);
@@ -0,0 +1 @@
+alter table sessions add column if not exists csrf_token_hash text;
@@ -0,0 +1,22 @@
+create table if not exists user_identities (
+	id            bigserial primary key,
+	user_id       bigint not null references users(id) on delete cascade,
+	issuer        text not null,
+	subject       text not null,
+	email         text,
+	created_at    timestamptz not null default now(),
+	last_login_at timestamptz,
+	unique (issuer, subject)
+);
+create index if not exists user_identities_user_id_idx on user_identities(user_id);
+
+create table if not exists oidc_states (
+	id            bigserial primary key,
+	state_hash    text not null unique,
+	key_id        text not null,
+	code_verifier text not null,
+	nonce         text not null,
+	link_user_id  bigint references users(id) on delete cascade,
+	expires_at    timestamptz not null,
+	created_at    timestamptz not null default now()
+);
@@ -0,0 +1,13 @@
+alter table sessions add column if not exists impersonator_id bigint references users(id) on delete cascade;
+
+create table if not exists audit_log (
+	id         bigserial primary key,
+	actor_id   bigint references users(id) on delete set null,
+	user_id    bigint references users(id) on delete set null,
+	action     text not null,
+	details    jsonb not null default '{}',
+	ip         text,
+	created_at timestamptz not null default now()
+);
+create index if not exists audit_log_user_id_idx on audit_log(user_id, created_at desc);
+create index if not exists audit_log_actor_id_idx on audit_log(actor_id, created_at desc);
@@ -0,0 +1,12 @@
+create table if not exists invitations (
+	id         bigserial primary key,
+	token_hash text not null unique,
+	key_id     text not null,
+	role_id    bigint not null references roles(id) on delete cascade,
+	note       text not null default '',
+	created_by bigint references users(id) on delete set null,
+	expires_at timestamptz not null,
+	used_at    timestamptz,
+	used_by    bigint references users(id) on delete set null,
+	created_at timestamptz not null default now()
+);
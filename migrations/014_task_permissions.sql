@@ -0,0 +1,9 @@
+insert into role_permissions(role_id, permission)
+select ro.id, p.permission
+from roles ro
+join (values
+	('manager', 'tasks.update'),
+	('manager', 'tasks.delete'),
+	('member', 'tasks.update')
+) as p(role, permission) on p.role = ro.name
+on conflict do nothing;
@@ -0,0 +1,6 @@
+alter table tasks add column if not exists priority text not null default 'normal'
+	check (priority in ('low', 'normal', 'high', 'urgent'));
+alter table tasks add column if not exists start_at timestamptz;
+alter table tasks add column if not exists due_at timestamptz;
+
+create index if not exists tasks_open_due_at_idx on tasks(due_at) where not is_completed;
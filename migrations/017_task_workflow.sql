@@ -0,0 +1,38 @@
+create table if not exists task_statuses (
+	name     text primary key,
+	position int not null default 0,
+	terminal boolean not null default false
+);
+
+create table if not exists task_transitions (
+	from_status text not null references task_statuses(name) on delete cascade,
+	to_status   text not null references task_statuses(name) on delete cascade,
+	permission  text not null default 'tasks.update',
+	primary key (from_status, to_status)
+);
+
+insert into task_statuses(name, position, terminal) values
+	('todo', 0, false),
+	('in_progress', 1, false),
+	('review', 2, false),
+	('done', 3, true),
+	('cancelled', 4, true)
+on conflict (name) do nothing;
+
+insert into task_transitions(from_status, to_status, permission) values
+	('todo', 'in_progress', 'tasks.update'),
+	('todo', 'cancelled', 'tasks.delete'),
+	('in_progress', 'todo', 'tasks.update'),
+	('in_progress', 'review', 'tasks.update'),
+	('in_progress', 'cancelled', 'tasks.delete'),
+	('review', 'in_progress', 'tasks.update'),
+	('review', 'done', 'tasks.complete'),
+	('done', 'in_progress', 'tasks.update'),
+	('cancelled', 'todo', 'tasks.update')
+on conflict do nothing;
+
+-- is_completed stays for old clients and always mirrors a terminal status.
+alter table tasks add column if not exists status text references task_statuses(name);
+update tasks set status = case when is_completed then 'done' else 'todo' end where status is null;
+alter table tasks alter column status set default 'todo';
+alter table tasks alter column status set not null;
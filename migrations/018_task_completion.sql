@@ -0,0 +1,10 @@
+alter table task_users add column if not exists completed_at timestamptz;
+
+alter table tasks add column if not exists completion_policy text not null default 'any'
+	check (completion_policy in ('any', 'all', 'owner'));
+alter table tasks add column if not exists owner_id bigint references users(id) on delete set null;
+
+-- Tasks completed before per-assignee tracking count as done by everyone.
+update task_users tu set completed_at = now()
+from tasks t
+where t.id = tu.task_id and t.is_completed and tu.completed_at is null;
@@ -0,0 +1,35 @@
+create table if not exists task_comments (
+	id         bigserial primary key,
+	task_id    bigint not null references tasks(id) on delete cascade,
+	author_id  bigint references users(id) on delete set null,
+	body       text not null,
+	created_at timestamptz not null default now(),
+	updated_at timestamptz
+);
+create index if not exists task_comments_task_id_idx on task_comments(task_id, id);
+
+create table if not exists task_comment_revisions (
+	id         bigserial primary key,
+	comment_id bigint not null references task_comments(id) on delete cascade,
+	body       text not null,
+	editor_id  bigint references users(id) on delete set null,
+	created_at timestamptz not null default now()
+);
+create index if not exists task_comment_revisions_comment_id_idx on task_comment_revisions(comment_id, id);
+
+create table if not exists notifications (
+	id         bigserial primary key,
+	user_id    bigint not null references users(id) on delete cascade,
+	kind       text not null,
+	actor_id   bigint references users(id) on delete set null,
+	task_id    bigint references tasks(id) on delete cascade,
+	comment_id bigint references task_comments(id) on delete cascade,
+	created_at timestamptz not null default now(),
+	read_at    timestamptz,
+	unique (user_id, kind, comment_id)
+);
+create index if not exists notifications_user_id_idx on notifications(user_id, id desc);
+
+insert into role_permissions(role_id, permission)
+select ro.id, 'tasks.comment' from roles ro where ro.name in ('manager', 'member')
+on conflict do nothing;
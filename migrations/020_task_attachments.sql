@@ -0,0 +1,16 @@
+create table if not exists task_attachments (
+	id           bigserial primary key,
+	task_id      bigint not null references tasks(id) on delete cascade,
+	uploader_id  bigint references users(id) on delete set null,
+	filename     text not null,
+	content_type text not null,
+	size         bigint not null,
+	sha256       text not null,
+	storage_key  text not null unique,
+	created_at   timestamptz not null default now()
+);
+create index if not exists task_attachments_task_id_idx on task_attachments(task_id, id);
+
+insert into role_permissions(role_id, permission)
+select ro.id, 'tasks.attach' from roles ro where ro.name in ('manager', 'member')
+on conflict do nothing;
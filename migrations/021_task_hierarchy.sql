@@ -0,0 +1,4 @@
+alter table tasks add column if not exists parent_id bigint references tasks(id) on delete set null;
+alter table tasks drop constraint if exists tasks_parent_not_self;
+alter table tasks add constraint tasks_parent_not_self check (parent_id <> id);
+create index if not exists tasks_parent_id_idx on tasks(parent_id);
@@ -0,0 +1,9 @@
+create table if not exists task_dependencies (
+	blocker_id bigint not null references tasks(id) on delete cascade,
+	blocked_id bigint not null references tasks(id) on delete cascade,
+	created_by bigint references users(id) on delete set null,
+	created_at timestamptz not null default now(),
+	primary key (blocker_id, blocked_id),
+	check (blocker_id <> blocked_id)
+);
+create index if not exists task_dependencies_blocked_id_idx on task_dependencies(blocked_id);
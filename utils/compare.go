@@ -10,5 +10,12 @@ func ComparePassword(password, hash string) error {
 	password = strings.TrimSpace(password)
 	hash = strings.TrimSpace(hash)
 
-	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	switch {
+	case strings.HasPrefix(hash, "$argon2id$"):
+		return compareArgon2id(password, hash)
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	default:
+		return ErrUnknownPasswordHash
+	}
 }
@@ -5,6 +5,55 @@ import (
 	"time"
 )
 
+const OIDCStateCookie = "oidc_state"
+
+func SetSessionCookie(w http.ResponseWriter, token string, expiresAt time.Time) {
+	maxAge := int(time.Until(expiresAt).Seconds())
+	if maxAge <= 0 {
+		ClearSessionCookie(w)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     "session_token",
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+		Expires:  expiresAt,
+		MaxAge:   maxAge,
+	})
+}
+
+// SetCSRFCookie exposes the CSRF token to the browser front end. It is not
+// HttpOnly on purpose: scripts on the sibling domain read it and send it back
+// in the X-CSRF-Token header.
+func SetCSRFCookie(w http.ResponseWriter, token string, expiresAt time.Time, domain string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "csrf_token",
+		Value:    token,
+		Path:     "/",
+		Domain:   domain,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+		Expires:  expiresAt,
+		MaxAge:   int(time.Until(expiresAt).Seconds()),
+	})
+}
+
+func ClearCSRFCookie(w http.ResponseWriter, domain string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "csrf_token",
+		Value:    "",
+		Path:     "/",
+		Domain:   domain,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+		Expires:  time.Unix(0, 0),
+		MaxAge:   -1,
+	})
+}
+
 func ClearSessionCookie(w http.ResponseWriter) {
 	http.SetCookie(w, &http.Cookie{
 		Name:     "session_token",
@@ -17,3 +66,32 @@ func ClearSessionCookie(w http.ResponseWriter) {
 		MaxAge:   -1,
 	})
 }
+
+// SetOIDCStateCookie binds an external sign-in to the browser that started
+// it. It is Lax, not Strict, because the callback is a cross-site redirect
+// from the identity provider.
+func SetOIDCStateCookie(w http.ResponseWriter, state string, expiresAt time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     OIDCStateCookie,
+		Value:    state,
+		Path:     "/auth/oidc/callback",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+		Expires:  expiresAt,
+		MaxAge:   int(time.Until(expiresAt).Seconds()),
+	})
+}
+
+func ClearOIDCStateCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     OIDCStateCookie,
+		Value:    "",
+		Path:     "/auth/oidc/callback",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+		Expires:  time.Unix(0, 0),
+		MaxAge:   -1,
+	})
+}
@@ -4,38 +4,108 @@ import (
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
-	"os"
-
-	"golang.org/x/crypto/bcrypt"
+	"errors"
+	"fmt"
+	"strings"
 )
 
-func HashPassword(password string) (hash string, err error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 12)
-	if err != nil {
-		return "", err
+// Keyring holds the HMAC keys used for token hashes. New hashes are always
+// made with the current key; previous keys are kept only so that tokens
+// issued before a rotation keep working until they are re-hashed or expire.
+type Keyring struct {
+	currentID string
+	order     []string
+	keys      map[string][]byte
+}
+
+var keyring *Keyring
+
+// InitKeyring installs the process-wide keyring. previous is a comma separated
+// list of id:secret pairs.
+func InitKeyring(currentID, currentSecret, previous string) error {
+	if strings.TrimSpace(currentSecret) == "" {
+		return errors.New("SECRET_KEY is not set")
+	}
+	if strings.TrimSpace(currentID) == "" {
+		return errors.New("SECRET_KEY_ID is not set")
 	}
-	return string(bytes), nil
+
+	ring := &Keyring{
+		currentID: currentID,
+		order:     []string{currentID},
+		keys:      map[string][]byte{currentID: []byte(currentSecret)},
+	}
+
+	for _, pair := range strings.Split(previous, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		id, secret, ok := strings.Cut(pair, ":")
+		if !ok || id == "" || secret == "" {
+			return fmt.Errorf("SECRET_KEYS_PREVIOUS: malformed entry %q, expected id:secret", pair)
+		}
+		if _, exists := ring.keys[id]; exists {
+			return fmt.Errorf("SECRET_KEYS_PREVIOUS: duplicate key id %q", id)
+		}
+		ring.order = append(ring.order, id)
+		ring.keys[id] = []byte(secret)
+	}
+
+	keyring = ring
+	return nil
 }
 
-func HashTokenHMAC(token string) string {
-	var SecretKey = []byte(os.Getenv("SECRET_KEY"))
+func mustKeyring() *Keyring {
+	if keyring == nil {
+		panic("utils: keyring is not initialized, call InitKeyring at startup")
+	}
+	return keyring
+}
 
-	mac := hmac.New(sha256.New, SecretKey)
+func hmacHex(key []byte, token string) string {
+	mac := hmac.New(sha256.New, key)
 	mac.Write([]byte(token))
 	return hex.EncodeToString(mac.Sum(nil))
 }
 
-func CompareTokenHash(token, hash string) bool {
-	secretKey := []byte(os.Getenv("SECRET_KEY"))
+func CurrentKeyID() string {
+	return mustKeyring().currentID
+}
 
-	mac := hmac.New(sha256.New, secretKey)
-	mac.Write([]byte(token))
-	expectedMAC := mac.Sum(nil)
+// KeyIDs returns the ids of all keys that are still accepted, current first.
+func KeyIDs() []string {
+	return append([]string(nil), mustKeyring().order...)
+}
 
+func HashTokenHMAC(token string) string {
+	ring := mustKeyring()
+	return hmacHex(ring.keys[ring.currentID], token)
+}
+
+// TokenHashes returns the hash of token under every accepted key, so lookups
+// can match rows written before the last rotation.
+func TokenHashes(token string) []string {
+	ring := mustKeyring()
+	hashes := make([]string, 0, len(ring.order))
+	for _, id := range ring.order {
+		hashes = append(hashes, hmacHex(ring.keys[id], token))
+	}
+	return hashes
+}
+
+func CompareTokenHash(token, hash string) bool {
 	hashBytes, err := hex.DecodeString(hash)
 	if err != nil {
 		return false
 	}
 
-	return hmac.Equal(expectedMAC, hashBytes)
+	ring := mustKeyring()
+	for _, id := range ring.order {
+		expected, _ := hex.DecodeString(hmacHex(ring.keys[id], token))
+		if hmac.Equal(expected, hashBytes) {
+			return true
+		}
+	}
+	return false
 }
@@ -2,7 +2,10 @@ package utils
 
 import (
 	"encoding/json"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 func WriteJSONError(w http.ResponseWriter, status int, errStr string, message string) {
@@ -30,6 +33,15 @@ func WriteJSON(w http.ResponseWriter, status int, data interface{}) {
 	json.NewEncoder(w).Encode(data)
 }
 
+func WriteJSONRetryAfter(w http.ResponseWriter, retryAfter time.Duration, errStr string, message string) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	WriteJSONError(w, http.StatusTooManyRequests, errStr, message)
+}
+
 func WriteJSONValidationError(w http.ResponseWriter, field, message string) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusBadRequest)
@@ -0,0 +1,24 @@
+package utils
+
+import (
+	"regexp"
+	"strings"
+)
+
+var mentionPattern = regexp.MustCompile(`(?:^|[^\w@])@([\w.\-]+)`)
+
+// ParseMentions returns the distinct logins mentioned as @login in text.
+// Trailing punctuation is not part of the login, so "@bob." mentions bob.
+func ParseMentions(text string) []string {
+	seen := map[string]bool{}
+	logins := []string{}
+	for _, match := range mentionPattern.FindAllStringSubmatch(text, -1) {
+		login := strings.TrimRight(match[1], ".-")
+		if login == "" || seen[login] {
+			continue
+		}
+		seen[login] = true
+		logins = append(logins, login)
+	}
+	return logins
+}
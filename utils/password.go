@@ -0,0 +1,157 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	AlgorithmBcrypt   = "bcrypt"
+	AlgorithmArgon2id = "argon2id"
+)
+
+var ErrUnknownPasswordHash = errors.New("unknown password hash format")
+
+// UnusablePasswordHash is stored for accounts that sign in only through an
+// external identity provider. ComparePassword never accepts it.
+const UnusablePasswordHash = "!"
+
+type PasswordHasher struct {
+	Algorithm string
+
+	BcryptCost int
+
+	Argon2Time    uint32
+	Argon2Memory  uint32 // KiB
+	Argon2Threads uint8
+	Argon2KeyLen  uint32
+	Argon2SaltLen uint32
+}
+
+var passwordHasher = PasswordHasher{
+	Algorithm:     AlgorithmBcrypt,
+	BcryptCost:    12,
+	Argon2Time:    3,
+	Argon2Memory:  64 * 1024,
+	Argon2Threads: 2,
+	Argon2KeyLen:  32,
+	Argon2SaltLen: 16,
+}
+
+func SetPasswordHasher(h PasswordHasher) error {
+	switch h.Algorithm {
+	case AlgorithmBcrypt:
+		if h.BcryptCost < bcrypt.MinCost || h.BcryptCost > bcrypt.MaxCost {
+			return fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+	case AlgorithmArgon2id:
+		if h.Argon2Time == 0 || h.Argon2Memory == 0 || h.Argon2Threads == 0 {
+			return errors.New("argon2id time, memory and threads must be positive")
+		}
+	default:
+		return fmt.Errorf("unknown password algorithm %q", h.Algorithm)
+	}
+	if h.Argon2KeyLen == 0 {
+		h.Argon2KeyLen = 32
+	}
+	if h.Argon2SaltLen == 0 {
+		h.Argon2SaltLen = 16
+	}
+	passwordHasher = h
+	return nil
+}
+
+func HashPassword(password string) (hash string, err error) {
+	if passwordHasher.Algorithm == AlgorithmArgon2id {
+		return hashArgon2id(password, passwordHasher)
+	}
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), passwordHasher.BcryptCost)
+	if err != nil {
+		return "", err
+	}
+	return string(bytes), nil
+}
+
+// PasswordNeedsRehash reports whether hash was made with another algorithm or
+// weaker parameters than the ones currently configured.
+func PasswordNeedsRehash(hash string) bool {
+	hash = strings.TrimSpace(hash)
+	switch passwordHasher.Algorithm {
+	case AlgorithmBcrypt:
+		cost, err := bcrypt.Cost([]byte(hash))
+		return err != nil || cost != passwordHasher.BcryptCost
+	case AlgorithmArgon2id:
+		params, _, _, err := parseArgon2id(hash)
+		return err != nil ||
+			params.Argon2Time != passwordHasher.Argon2Time ||
+			params.Argon2Memory != passwordHasher.Argon2Memory ||
+			params.Argon2Threads != passwordHasher.Argon2Threads ||
+			params.Argon2KeyLen != passwordHasher.Argon2KeyLen
+	}
+	return false
+}
+
+func hashArgon2id(password string, h PasswordHasher) (string, error) {
+	salt := make([]byte, h.Argon2SaltLen)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.Argon2Time, h.Argon2Memory, h.Argon2Threads, h.Argon2KeyLen)
+	return fmt.Sprintf(
+		"$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.Argon2Memory, h.Argon2Time, h.Argon2Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func parseArgon2id(hash string) (params PasswordHasher, salt, key []byte, err error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != AlgorithmArgon2id {
+		return params, nil, nil, ErrUnknownPasswordHash
+	}
+
+	var version int
+	_, err = fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return params, nil, nil, ErrUnknownPasswordHash
+	}
+
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Argon2Memory, &params.Argon2Time, &params.Argon2Threads)
+	if err != nil {
+		return params, nil, nil, ErrUnknownPasswordHash
+	}
+
+	salt, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, ErrUnknownPasswordHash
+	}
+	key, err = base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, ErrUnknownPasswordHash
+	}
+	params.Algorithm = AlgorithmArgon2id
+	params.Argon2KeyLen = uint32(len(key))
+	params.Argon2SaltLen = uint32(len(salt))
+	return params, salt, key, nil
+}
+
+func compareArgon2id(password, hash string) error {
+	params, salt, key, err := parseArgon2id(hash)
+	if err != nil {
+		return err
+	}
+	actual := argon2.IDKey([]byte(password), salt, params.Argon2Time, params.Argon2Memory, params.Argon2Threads, params.Argon2KeyLen)
+	if subtle.ConstantTimeCompare(actual, key) != 1 {
+		return bcrypt.ErrMismatchedHashAndPassword
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Secrets that must be read back, such as TOTP seeds, are stored as
+// "enc:<key id>:<base64 nonce+ciphertext>", sealed with AES-256-GCM under a
+// key derived from the keyring entry of that id.
+const encryptedPrefix = "enc:"
+
+var ErrSecretKeyUnknown = errors.New("secret was encrypted with a key that is no longer in the keyring")
+
+func sealKey(id string) ([]byte, bool) {
+	secret, ok := mustKeyring().keys[id]
+	if !ok {
+		return nil, false
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("secret-box/v1"))
+	return mac.Sum(nil), true
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// EncryptSecret seals plaintext with the current key.
+func EncryptSecret(plaintext string) (string, error) {
+	id := CurrentKeyID()
+	key, _ := sealKey(id)
+	aead, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), []byte(id))
+	return encryptedPrefix + id + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// DecryptSecret opens a value made by EncryptSecret. Values without the
+// prefix predate encryption and are returned unchanged.
+func DecryptSecret(stored string) (string, error) {
+	rest, ok := strings.CutPrefix(stored, encryptedPrefix)
+	if !ok {
+		return stored, nil
+	}
+	id, encoded, ok := strings.Cut(rest, ":")
+	if !ok {
+		return "", errors.New("malformed encrypted secret")
+	}
+	key, ok := sealKey(id)
+	if !ok {
+		return "", ErrSecretKeyUnknown
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("malformed encrypted secret: %w", err)
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", errors.New("malformed encrypted secret")
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(id))
+	if err != nil {
+		return "", fmt.Errorf("encrypted secret: %w", err)
+	}
+	return string(plaintext), nil
+}
+
+// SecretNeedsReseal reports whether a stored secret is in plaintext or
+// sealed with a key other than the current one.
+func SecretNeedsReseal(stored string) bool {
+	return !strings.HasPrefix(stored, encryptedPrefix+CurrentKeyID()+":")
+}
@@ -3,8 +3,11 @@ package utils
 import (
 	"crypto/rand"
 	"encoding/base64"
+	"strings"
 )
 
+const APIKeyPrefix = "rak_"
+
 func GenerateSessionToken() (string, error) {
 	b := make([]byte, 32) // 256 бит
 	_, err := rand.Read(b)
@@ -13,3 +16,15 @@ func GenerateSessionToken() (string, error) {
 	}
 	return base64.URLEncoding.EncodeToString(b), nil
 }
+
+func GenerateAPIKey() (string, error) {
+	token, err := GenerateSessionToken()
+	if err != nil {
+		return "", err
+	}
+	return APIKeyPrefix + strings.TrimRight(token, "="), nil
+}
+
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, APIKeyPrefix)
+}
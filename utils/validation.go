@@ -2,6 +2,7 @@ package utils
 
 import (
 	"strings"
+	"time"
 )
 
 func ValidateUserRequest(login, family, name, surname, password string) error {
@@ -17,11 +18,15 @@ func ValidateUserRequest(login, family, name, surname, password string) error {
 	if strings.TrimSpace(surname) == "" {
 		return &ValidationError{Field: "surname", Message: "surname is required"}
 	}
+	return ValidatePassword("password", password)
+}
+
+func ValidatePassword(field, password string) error {
 	if strings.TrimSpace(password) == "" {
-		return &ValidationError{Field: "password", Message: "password is required"}
+		return &ValidationError{Field: field, Message: field + " is required"}
 	}
 	if len(password) < 6 {
-		return &ValidationError{Field: "password", Message: "password must be at least 6 characters"}
+		return &ValidationError{Field: field, Message: field + " must be at least 6 characters"}
 	}
 	return nil
 }
@@ -33,6 +38,62 @@ func ValidateTaskRequest(title, description string) error {
 	return nil
 }
 
+// TaskPriorities lists the priority levels from lowest to highest.
+var TaskPriorities = []string{"low", "normal", "high", "urgent"}
+
+const DefaultTaskPriority = "normal"
+
+func ValidateTaskPlanning(priority string, startAt, dueAt *time.Time) error {
+	known := false
+	for _, p := range TaskPriorities {
+		if p == priority {
+			known = true
+		}
+	}
+	if !known {
+		return &ValidationError{Field: "priority", Message: "priority must be one of " + strings.Join(TaskPriorities, ", ")}
+	}
+	if startAt != nil && dueAt != nil && dueAt.Before(*startAt) {
+		return &ValidationError{Field: "due_at", Message: "due_at must not be before start_at"}
+	}
+	return nil
+}
+
+var TaskCompletionPolicies = []string{"any", "all", "owner"}
+
+const DefaultCompletionPolicy = "any"
+
+func ValidateCompletionPolicy(policy string, ownerID *int64) error {
+	known := false
+	for _, p := range TaskCompletionPolicies {
+		if p == policy {
+			known = true
+		}
+	}
+	if !known {
+		return &ValidationError{Field: "completion_policy", Message: "completion_policy must be one of " + strings.Join(TaskCompletionPolicies, ", ")}
+	}
+	if ownerID != nil && *ownerID <= 0 {
+		return &ValidationError{Field: "owner_id", Message: "owner_id must be a positive integer"}
+	}
+	if policy == "owner" && ownerID == nil {
+		return &ValidationError{Field: "owner_id", Message: "owner_id is required when completion_policy is owner"}
+	}
+	return nil
+}
+
+const MaxCommentLength = 10000
+
+func ValidateCommentBody(body string) error {
+	if strings.TrimSpace(body) == "" {
+		return &ValidationError{Field: "body", Message: "body is required"}
+	}
+	if len(body) > MaxCommentLength {
+		return &ValidationError{Field: "body", Message: "body is too long"}
+	}
+	return nil
+}
+
 func ValidateLoginRequest(login, password string) error {
 	if strings.TrimSpace(login) == "" {
 		return &ValidationError{Field: "login", Message: "login is required"}